@@ -0,0 +1,242 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+)
+
+// 登录尝试和白名单条目的解锁方式
+const (
+	MethodPassword = "password"
+	MethodKnock    = "knock"
+	MethodSPA      = "spa"
+	MethodSSH      = "ssh"
+	MethodDNS      = "dns"
+	MethodLink     = "link"
+	MethodRequest  = "request"
+	// MethodKnockIncomplete 为未完成的敲门序列，与端口扫描无法区分，
+	// 只记录不计入锁定（GetRecentFailedAttempts 忽略该方式）
+	MethodKnockIncomplete = "knock_incomplete"
+)
+
+// 默认登录（未选择服务）使用的策略
+const (
+	DefaultMaxFailedAttempts = 5
+	DefaultLockoutDuration   = 15 * time.Minute
+	DefaultGrantDuration     = 24 * time.Hour
+)
+
+var (
+	// ErrLockedOut 表示该IP对此策略的失败次数已达到上限
+	ErrLockedOut = errors.New("too many failed attempts")
+	// ErrDurationTooLong 表示申请的授权时长超过了策略允许的最长时长
+	ErrDurationTooLong = errors.New("duration exceeds policy maximum")
+)
+
+// Policy 描述一次解锁所使用的凭据和限制，来自某个服务或默认的用户配置
+type Policy struct {
+	ServiceID         int
+	ServiceName       string
+	PasswordHash      string
+	Ports             string
+	GrantDuration     time.Duration
+	MaxFailedAttempts int
+	LockoutDuration   time.Duration
+}
+
+// ResolvePolicy 返回服务的访问策略，serviceID 为0时使用全局用户密码、端口和最长时长
+func ResolvePolicy(serviceID int) (*Policy, error) {
+	if serviceID == 0 {
+		config, err := database.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		grantDuration := DefaultGrantDuration
+		if config.UserMaxDurationMinutes > 0 {
+			grantDuration = time.Duration(config.UserMaxDurationMinutes) * time.Minute
+		}
+		return &Policy{
+			PasswordHash:      config.UserPassword,
+			Ports:             config.UserPorts,
+			GrantDuration:     grantDuration,
+			MaxFailedAttempts: DefaultMaxFailedAttempts,
+			LockoutDuration:   DefaultLockoutDuration,
+		}, nil
+	}
+
+	service, err := database.GetService(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	return policyFromService(service), nil
+}
+
+func policyFromService(s *models.Service) *Policy {
+	return &Policy{
+		ServiceID:         s.ID,
+		ServiceName:       s.Name,
+		PasswordHash:      s.Password,
+		Ports:             s.Ports,
+		GrantDuration:     time.Duration(s.MaxDurationMinutes) * time.Minute,
+		MaxFailedAttempts: s.MaxFailedAttempts,
+		LockoutDuration:   time.Duration(s.LockoutMinutes) * time.Minute,
+	}
+}
+
+// IsLockedOut 检查该IP对此策略的失败次数是否已达到上限
+func (p *Policy) IsLockedOut(ip string) bool {
+	failed, err := database.GetRecentFailedAttempts(ip, p.ServiceID, p.LockoutDuration)
+	if err != nil {
+		log.Printf("Error checking failed attempts: %v", err)
+		return false
+	}
+	return failed >= p.MaxFailedAttempts
+}
+
+// CheckPassword 校验密码并记录登录尝试
+func (p *Policy) CheckPassword(ip, password string) bool {
+	ok := bcrypt.CompareHashAndPassword([]byte(p.PasswordHash), []byte(password)) == nil
+	p.RecordAttempt(ip, MethodPassword, ok)
+	return ok
+}
+
+// lockoutMu 使检查和记录锁定成为一步，避免同一次越限被记录多次
+var lockoutMu sync.Mutex
+
+// RecordAttempt 记录一次登录尝试，失败次数达到上限时记录一次锁定。
+// 各种解锁方式的失败共用同一个计数
+func (p *Policy) RecordAttempt(ip, method string, ok bool) {
+	err := database.RecordLoginAttempt(models.LoginAttempt{
+		IP:        ip,
+		ServiceID: p.ServiceID,
+		Method:    method,
+		Success:   ok,
+		Country:   geoip.Country(ip),
+	})
+	if err != nil {
+		log.Printf("Error recording login attempt: %v", err)
+	}
+
+	// 失败次数达到上限且锁定期内尚未记录过锁定时算作一次锁定，用于自动封禁。
+	// 并发的失败可能一起越过上限，因此不能只在刚好等于上限时记录
+	if !ok {
+		lockoutMu.Lock()
+		defer lockoutMu.Unlock()
+		failed, err := database.GetRecentFailedAttempts(ip, p.ServiceID, p.LockoutDuration)
+		if err != nil || failed < p.MaxFailedAttempts {
+			return
+		}
+		locked, err := database.HasLockoutSince(ip, p.ServiceID, time.Now().Add(-p.LockoutDuration))
+		if err != nil {
+			log.Printf("Error checking lockouts: %v", err)
+			return
+		}
+		if !locked {
+			recordLockout(ip, p.ServiceID)
+		}
+	}
+}
+
+// LimitDuration 把授权时长改为 minutes 分钟，0表示使用策略允许的最长时长，
+// 超过最长时长时返回错误且不修改策略
+func (p *Policy) LimitDuration(minutes int) error {
+	if minutes == 0 {
+		return nil
+	}
+	if minutes < 0 || time.Duration(minutes)*time.Minute > p.GrantDuration {
+		return ErrDurationTooLong
+	}
+	p.GrantDuration = time.Duration(minutes) * time.Minute
+	return nil
+}
+
+// Grant 将IP按策略临时加入白名单，写入数据库并更新防火墙，返回过期时间。
+// method 为解锁方式，记录在条目上。来源国家不符合国家策略时返回 ErrCountryDenied
+func Grant(ip, method string, p *Policy, description string) (time.Time, error) {
+	return GrantUser(ip, 0, method, p, description)
+}
+
+// GrantUser 与 Grant 相同，但条目归属于 userID 对应的已识别用户，
+// 用户同时放行的IP数超过上限时撤销最早授权的IP
+func GrantUser(ip string, userID int, method string, p *Policy, description string) (time.Time, error) {
+	country, err := CheckCountry(ip)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(p.GrantDuration)
+	entry, err := database.AddWhitelistIP(models.WhitelistIP{
+		IP:          ip,
+		Description: description,
+		ExpiresAt:   expiresAt,
+		Ports:       p.Ports,
+		ServiceID:   p.ServiceID,
+		Country:     country,
+		UserID:      userID,
+		Source:      method,
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to whitelist IP in database: %v", err)
+	}
+
+	// 已有的永久条目或更晚的过期时间不会被本次授权缩短，端口也以保存的条目为准
+	if err := iptables.AddIPToWhitelist(entry.ID, ip, entry.Ports); err != nil {
+		return time.Time{}, fmt.Errorf("failed to update firewall: %v", err)
+	}
+	if !entry.IsPermanent {
+		expiresAt = entry.ExpiresAt
+	}
+
+	database.AddWhitelistHistory(entry.ID, "granted", fmt.Sprintf("%s until %s", description, expiresAt.Format(time.RFC3339)))
+
+	if userID != 0 {
+		if err := rotateUserIPs(userID, ip); err != nil {
+			log.Printf("Error rotating IPs of user %d: %v", userID, err)
+		}
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+	return expiresAt, nil
+}
+
+// SelfExtendable 返回用户能否在状态页自行延长该条目。只有用户凭自己的凭据获得的授权可以延长，
+// 访问链接、访问申请和管理员添加的条目时长由管理员决定
+func SelfExtendable(entry *models.WhitelistIP) bool {
+	switch entry.Source {
+	case MethodPassword, MethodKnock, MethodSPA, MethodSSH, MethodDNS:
+		return true
+	}
+	return false
+}
+
+// Extend 将临时条目的过期时间延长到现在起策略允许的最长时长，返回新的过期时间。
+// 与重新登录一样检查国家和锁定，不会缩短已有的过期时间
+func Extend(entry *models.WhitelistIP, p *Policy) (time.Time, error) {
+	if _, err := CheckCountry(entry.IP); err != nil {
+		return time.Time{}, err
+	}
+	if p.IsLockedOut(entry.IP) {
+		return time.Time{}, ErrLockedOut
+	}
+
+	expiresAt := time.Now().Add(p.GrantDuration)
+	if !expiresAt.After(entry.ExpiresAt) {
+		return entry.ExpiresAt, nil
+	}
+	if err := database.ExtendWhitelistIP(entry.ID, expiresAt); err != nil {
+		return time.Time{}, err
+	}
+	database.AddWhitelistHistory(entry.ID, "extended", "until "+expiresAt.Format(time.RFC3339))
+	return expiresAt, nil
+}
@@ -0,0 +1,124 @@
+package access
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+)
+
+// BanHistoryWindow 为计算封禁级别时回看的时间，更早的封禁不再使下一次封禁加倍
+const BanHistoryWindow = 30 * 24 * time.Hour
+
+// BanDuration 返回指定级别的封禁时长：第一次为基础时长，之后每级翻倍，不超过最长时长
+func BanDuration(config *models.Config, level int) time.Duration {
+	max := time.Duration(config.BanMaxMinutes) * time.Minute
+	d := time.Duration(config.BanBaseMinutes) * time.Minute
+	for i := 0; i < level && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// recordLockout 在IP对某个策略的失败次数达到上限时调用。
+// 窗口内（且在上一次封禁之后）的锁定次数达到阈值时在防火墙层面封禁该IP
+func recordLockout(ip string, serviceID int) {
+	if err := database.RecordLockout(ip, serviceID); err != nil {
+		log.Printf("Error recording lockout: %v", err)
+		return
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting ban policy: %v", err)
+		return
+	}
+	if config.BanAfterLockouts <= 0 {
+		return
+	}
+
+	now := time.Now()
+	since := now.Add(-time.Duration(config.BanWindowMinutes) * time.Minute)
+	latest, err := database.GetLatestBan(ip)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error getting latest ban of %s: %v", ip, err)
+		return
+	}
+	if latest != nil {
+		if latest.LiftedAt == nil && latest.ExpiresAt.After(now) {
+			return
+		}
+		if latest.CreatedAt.After(since) {
+			since = latest.CreatedAt
+		}
+	}
+
+	lockouts, err := database.CountLockouts(ip, since)
+	if err != nil {
+		log.Printf("Error counting lockouts of %s: %v", ip, err)
+		return
+	}
+	if lockouts < config.BanAfterLockouts {
+		return
+	}
+
+	// 已在白名单中的地址不封禁，避免共用出口IP的正常用户被锁在外面
+	if whitelisted, err := database.IsIPWhitelisted(ip); err == nil && whitelisted {
+		log.Printf("Not banning whitelisted IP %s after %d lockout(s)", ip, lockouts)
+		return
+	}
+
+	reason := fmt.Sprintf("%d lockout(s) within %d minute(s)", lockouts, config.BanWindowMinutes)
+	if _, err := Ban(ip, reason); err != nil {
+		log.Printf("Error banning %s: %v", ip, err)
+	}
+}
+
+// Ban 在防火墙层面封禁IP，级别由近期未被解封的封禁次数决定
+func Ban(ip, reason string) (*models.Ban, error) {
+	// 只封禁单个地址，网段会把其他用户甚至管理员一起挡在外面
+	if net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	level, err := database.CountRecentBans(ip, now.Add(-BanHistoryWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	ban := models.Ban{
+		IP:        ip,
+		Level:     level,
+		Reason:    reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(BanDuration(config, level)),
+	}
+	ban.ID, err = database.AddBan(ban)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save ban: %v", err)
+	}
+
+	if err := iptables.ApplyBanRule(ban.ID, ip); err != nil {
+		return nil, fmt.Errorf("failed to update firewall: %v", err)
+	}
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	log.Printf("Banned %s until %s (level %d): %s", ip, ban.ExpiresAt.Format(time.RFC3339), level, reason)
+	return &ban, nil
+}
@@ -0,0 +1,72 @@
+package access
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"iptables-safe/database"
+	"iptables-safe/models"
+	"iptables-safe/notify"
+)
+
+// 待审批变更的状态
+const (
+	ChangePending  = "pending"
+	ChangeApproved = "approved"
+	ChangeRejected = "rejected"
+	ChangeExpired  = "expired"
+)
+
+// ChangeWhitelistAdd 为添加白名单条目的变更类型
+const ChangeWhitelistAdd = "whitelist_add"
+
+// RequiresApproval 返回添加该条目是否需要第二位管理员批准。
+// 永久条目、有效期超过 FourEyesMaxHours 的临时条目和比 FourEyesMinPrefix 更宽的网段受此策略限制
+func RequiresApproval(entry models.WhitelistIP, config *models.Config) bool {
+	if !config.FourEyesPermanent {
+		return false
+	}
+	if entry.IsPermanent {
+		return true
+	}
+	if _, network, err := net.ParseCIDR(entry.IP); err == nil {
+		if ones, _ := network.Mask.Size(); ones < config.FourEyesMinPrefix {
+			return true
+		}
+	}
+	return entry.ExpiresAt.After(time.Now().Add(time.Duration(config.FourEyesMaxHours) * time.Hour))
+}
+
+// CheckApprover 验证审批人密码，尚未设置密码时总是失败
+func CheckApprover(config *models.Config, password string) bool {
+	if config.ApproverPassword == "" || password == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(config.ApproverPassword), []byte(password)) == nil
+}
+
+// PendingExpiry 返回现在提交的变更的过期时间
+func PendingExpiry(config *models.Config, now time.Time) time.Time {
+	hours := config.PendingTTLHours
+	if hours <= 0 {
+		hours = 24
+	}
+	return now.Add(time.Duration(hours) * time.Hour)
+}
+
+// ExpireChanges 将超时未批准的变更标记为过期并发送通知，由后台任务定期调用
+func ExpireChanges() error {
+	expired, err := database.ExpirePendingChanges(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, c := range expired {
+		log.Printf("Pending change %d expired without approval: %s", c.ID, c.Summary)
+		notify.Send("pending_change.expired",
+			fmt.Sprintf("Pending change #%d expired without approval: %s", c.ID, c.Summary), c)
+	}
+	return nil
+}
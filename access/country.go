@@ -0,0 +1,85 @@
+package access
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+	"iptables-safe/models"
+)
+
+// 国家策略
+const (
+	CountryAny   = ""      // 不限制
+	CountryAllow = "allow" // 只允许列表中的国家
+	CountryDeny  = "deny"  // 拒绝列表中的国家
+)
+
+// ErrCountryDenied 表示来源IP所属国家不允许登录或解锁
+var ErrCountryDenied = errors.New("country not allowed")
+
+// IsValidCountryMode 检查国家策略是否合法
+func IsValidCountryMode(mode string) bool {
+	return mode == CountryAny || mode == CountryAllow || mode == CountryDeny
+}
+
+// NormalizeCountries 将逗号分隔的国家代码整理为去重的大写形式，如 "cn, hk" -> "CN,HK"
+func NormalizeCountries(countries string) (string, error) {
+	var codes []string
+	seen := make(map[string]bool)
+	for _, code := range strings.Split(countries, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" || seen[code] {
+			continue
+		}
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return "", errors.New("invalid country code " + code)
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return strings.Join(codes, ","), nil
+}
+
+// CountryAllowed 按配置判断来源是否允许。内网和本机地址不受限制；
+// allow 模式下查不到国家（包括未加载GeoIP数据库）的公网地址会被拒绝
+func CountryAllowed(config *models.Config, ip, country string) bool {
+	if config.CountryMode == CountryAny {
+		return true
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+
+	listed := false
+	if country != "" {
+		for _, code := range strings.Split(config.Countries, ",") {
+			if code == country {
+				listed = true
+				break
+			}
+		}
+	}
+	if config.CountryMode == CountryAllow {
+		return listed
+	}
+	return !listed
+}
+
+// CheckCountry 查询IP所属国家并按当前配置判断是否允许
+func CheckCountry(ip string) (string, error) {
+	country := geoip.Country(ip)
+	config, err := database.GetConfig()
+	if err != nil {
+		return country, err
+	}
+	if !CountryAllowed(config, ip, country) {
+		return country, ErrCountryDenied
+	}
+	return country, nil
+}
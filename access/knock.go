@@ -0,0 +1,100 @@
+package access
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+)
+
+var (
+	knockMu sync.Mutex
+	// knockStarted 记录开始敲门但尚未完成的地址及首次发现的时间
+	knockStarted = make(map[string]time.Time)
+)
+
+// ProcessKnocks 读取敲门进度：完成序列的地址按默认用户策略解锁，
+// 中途敲错或超时的地址清除进度并记录到登录记录。由后台任务每秒调用
+func ProcessKnocks() error {
+	knockMu.Lock()
+	defer knockMu.Unlock()
+
+	if !iptables.KnockEnabled() {
+		knockStarted = make(map[string]time.Time)
+		return nil
+	}
+
+	completed, err := iptables.ReadKnockCompletions()
+	if err != nil {
+		return err
+	}
+	for _, ip := range completed {
+		iptables.ClearKnock(ip)
+		delete(knockStarted, ip)
+		grantKnock(ip)
+	}
+
+	inProgress, err := iptables.ReadKnockProgress()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	current := make(map[string]bool)
+	for _, ip := range inProgress {
+		current[ip] = true
+		if _, ok := knockStarted[ip]; !ok {
+			knockStarted[ip] = now
+		}
+	}
+
+	// 不再处于进度列表中（敲错端口被清除）或超过最长序列的时限仍未完成的都放弃。
+	// 端口扫描碰巧命中第一个敲门端口也会留下进度，不能与敲错区分，因此以单独的方式记录，
+	// 不计入登录失败，否则扫描噪声会锁定或封禁与扫描器共用出口的正常用户
+	for ip, started := range knockStarted {
+		if current[ip] && now.Sub(started) <= iptables.MaxKnockSteps*iptables.KnockStepTimeout {
+			continue
+		}
+		iptables.ClearKnock(ip)
+		delete(knockStarted, ip)
+		log.Printf("Incomplete knock sequence from %s", ip)
+		err := database.RecordLoginAttempt(models.LoginAttempt{
+			IP:      ip,
+			Method:  MethodKnockIncomplete,
+			Success: false,
+			Country: geoip.Country(ip),
+		})
+		if err != nil {
+			log.Printf("Error recording login attempt: %v", err)
+		}
+	}
+	return nil
+}
+
+func grantKnock(ip string) {
+	policy, err := ResolvePolicy(0)
+	if err != nil {
+		log.Printf("Error getting login policy for knock from %s: %v", ip, err)
+		return
+	}
+	if policy.IsLockedOut(ip) {
+		log.Printf("Ignored knock from locked out IP %s", ip)
+		return
+	}
+
+	expiresAt, err := Grant(ip, MethodKnock, policy, "Port knock")
+	if errors.Is(err, ErrCountryDenied) {
+		log.Printf("Rejected knock from %s: country not allowed", ip)
+		return
+	}
+	if err != nil {
+		log.Printf("Error whitelisting IP %s after knock: %v", ip, err)
+		return
+	}
+	policy.RecordAttempt(ip, MethodKnock, true)
+	log.Printf("IP %s whitelisted by port knock until %s", ip, expiresAt.Format(time.RFC3339))
+}
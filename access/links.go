@@ -0,0 +1,157 @@
+package access
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/models"
+)
+
+var (
+	ErrLinkInvalid    = errors.New("invalid link")
+	ErrLinkRevoked    = errors.New("link has been revoked")
+	ErrLinkExpired    = errors.New("link has expired")
+	ErrLinkUsedUp     = errors.New("link has no remaining uses")
+	ErrLinkNotAllowed = errors.New("service not allowed by this link")
+)
+
+// linkKey 返回访问链接的签名密钥，首次使用时生成
+func linkKey() ([]byte, error) {
+	config, err := database.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	key := config.LinkKey
+	if key == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		if key, err = database.InitLinkKey(hex.EncodeToString(b)); err != nil {
+			return nil, err
+		}
+	}
+	return hex.DecodeString(key)
+}
+
+func linkSignature(key []byte, id int) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("iptables-safe access link\x00"))
+	mac.Write([]byte(strconv.Itoa(id)))
+	return mac.Sum(nil)
+}
+
+// SignLink 返回链接的令牌 "<ID>.<签名>"，用于拼接访问URL。
+// 链接的次数、期限和服务保存在数据库中，签名只防止猜测其他链接的ID
+func SignLink(id int) (string, error) {
+	key, err := linkKey()
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(id) + "." + base64.RawURLEncoding.EncodeToString(linkSignature(key, id)), nil
+}
+
+// LookupLink 校验令牌的签名并返回对应的链接，签名无效或链接不存在时返回 ErrLinkInvalid
+func LookupLink(token string) (*models.AccessLink, error) {
+	idPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrLinkInvalid
+	}
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return nil, ErrLinkInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, ErrLinkInvalid
+	}
+
+	key, err := linkKey()
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(sig, linkSignature(key, id)) {
+		return nil, ErrLinkInvalid
+	}
+
+	link, err := database.GetAccessLink(id)
+	if err != nil {
+		return nil, ErrLinkInvalid
+	}
+	return link, nil
+}
+
+// CheckLink 返回链接当前不可用的原因，可用时返回nil
+func CheckLink(link *models.AccessLink, now time.Time) error {
+	switch {
+	case link.RevokedAt != nil:
+		return ErrLinkRevoked
+	case !now.Before(link.ExpiresAt):
+		return ErrLinkExpired
+	case link.Uses >= link.MaxUses:
+		return ErrLinkUsedUp
+	}
+	return nil
+}
+
+// MaxLinkDuration 返回可以解锁 serviceIDs 的链接允许的最长授权时长：不超过每个服务策略的授权时长，
+// 启用四眼原则时也不超过 FourEyesMaxHours，否则链接可以绕过第二位管理员的批准
+func MaxLinkDuration(serviceIDs []int, config *models.Config) (time.Duration, error) {
+	var max time.Duration
+	for i, id := range serviceIDs {
+		policy, err := ResolvePolicy(id)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || policy.GrantDuration < max {
+			max = policy.GrantDuration
+		}
+	}
+	if config.FourEyesPermanent {
+		if limit := time.Duration(config.FourEyesMaxHours) * time.Hour; limit < max {
+			max = limit
+		}
+	}
+	return max, nil
+}
+
+// LinkPolicy 返回通过链接解锁 serviceID 时使用的策略：端口和锁定规则来自服务，
+// 授权时长由链接决定，但不超过 MaxLinkDuration（策略在链接创建后可能被收紧）
+func LinkPolicy(link *models.AccessLink, serviceID int) (*Policy, error) {
+	allowed := false
+	for _, id := range link.ServiceIDs {
+		if id == serviceID {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, ErrLinkNotAllowed
+	}
+
+	policy, err := ResolvePolicy(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := database.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	max, err := MaxLinkDuration([]int{serviceID}, config)
+	if err != nil {
+		return nil, err
+	}
+	policy.GrantDuration = time.Duration(link.DurationMinutes) * time.Minute
+	if policy.GrantDuration > max {
+		policy.GrantDuration = max
+	}
+	return policy, nil
+}
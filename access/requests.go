@@ -0,0 +1,42 @@
+package access
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/notify"
+)
+
+// 访问申请的状态
+const (
+	RequestPending  = "pending"
+	RequestApproved = "approved"
+	RequestDenied   = "denied"
+	RequestExpired  = "expired"
+)
+
+const (
+	// RequestTTL 为申请等待处理的时限，超时未处理的申请自动过期，申请人需要重新提交
+	RequestTTL = time.Hour
+	// MaxPendingRequests 为同一IP同时等待处理的申请数量上限
+	MaxPendingRequests = 3
+	// MaxTotalPendingRequests 为所有IP同时等待处理的申请数量上限。每个申请都会发送通知，
+	// 达到上限后拒绝新的申请，直到管理员处理或申请过期，避免大量地址刷屏
+	MaxTotalPendingRequests = 20
+)
+
+// ExpireRequests 将超时未处理的申请标记为过期并发送通知，由后台任务定期调用
+func ExpireRequests() error {
+	expired, err := database.ExpireAccessRequests(time.Now().Add(-RequestTTL))
+	if err != nil {
+		return err
+	}
+	for _, r := range expired {
+		log.Printf("Access request %d from %s (%s) expired without a decision", r.ID, r.Name, r.IP)
+		notify.Send("access_request.expired",
+			fmt.Sprintf("Access request #%d from %s (%s) expired without a decision", r.ID, r.Name, r.IP), r)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package access
+
+import (
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/models"
+)
+
+// 永久条目需要复核的原因
+const (
+	ReviewNoTraffic = "no_traffic" // 超过设定天数没有流量
+	ReviewDue       = "review_due" // 距上次复核超过设定天数
+)
+
+// ReviewItem 是复核队列中的一个永久条目
+type ReviewItem struct {
+	Entry   models.WhitelistIP `json:"entry"`
+	Reasons []string           `json:"reasons"`
+}
+
+// ReviewReasons 返回永久条目需要复核的原因，不需要复核时返回空。
+// 复核通过后两项检查都从复核时间重新计算
+func ReviewReasons(entry models.WhitelistIP, config *models.Config, now time.Time) []string {
+	if !entry.IsPermanent {
+		return nil
+	}
+
+	reviewed := entry.CreatedAt
+	if entry.LastReviewedAt != nil && entry.LastReviewedAt.After(reviewed) {
+		reviewed = *entry.LastReviewedAt
+	}
+	active := reviewed
+	if entry.LastActiveAt != nil && entry.LastActiveAt.After(active) {
+		active = *entry.LastActiveAt
+	}
+
+	var reasons []string
+	if config.StaleAfterDays > 0 && now.Sub(active) > days(config.StaleAfterDays) {
+		reasons = append(reasons, ReviewNoTraffic)
+	}
+	if config.ReviewIntervalDays > 0 && now.Sub(reviewed) > days(config.ReviewIntervalDays) {
+		reasons = append(reasons, ReviewDue)
+	}
+	return reasons
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// PendingReviews 返回所有需要复核的永久条目
+func PendingReviews() ([]ReviewItem, error) {
+	config, err := database.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := database.GetAllWhitelistIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	items := []ReviewItem{}
+	for _, entry := range entries {
+		if reasons := ReviewReasons(entry, config, now); len(reasons) > 0 {
+			items = append(items, ReviewItem{Entry: entry, Reasons: reasons})
+		}
+	}
+	return items, nil
+}
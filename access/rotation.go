@@ -0,0 +1,54 @@
+package access
+
+import (
+	"fmt"
+	"log"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/notify"
+)
+
+// rotateUserIPs 在用户同时放行的IP数超过上限时，撤销最早授权的IP上的全部条目。
+// 连接按 flush_connections_on_revoke 断开，仍被其他条目覆盖的连接保留。
+// current 为刚刚授权的IP，总是保留
+func rotateUserIPs(userID int, current string) error {
+	config, err := database.GetConfig()
+	if err != nil {
+		return err
+	}
+	if config.MaxIPsPerUser <= 0 {
+		return nil
+	}
+
+	entries, err := database.GetActiveUserWhitelistIPs(userID)
+	if err != nil {
+		return err
+	}
+
+	// 条目按授权时间从新到旧排列，IP按其最近一次授权排序
+	kept := map[string]bool{current: true}
+	for _, entry := range entries {
+		if kept[entry.IP] {
+			continue
+		}
+		if len(kept) < config.MaxIPsPerUser {
+			kept[entry.IP] = true
+			continue
+		}
+
+		detail := fmt.Sprintf("%s exceeded %d concurrent IP(s), replaced by %s", entry.UserName, config.MaxIPsPerUser, current)
+		database.AddWhitelistHistory(entry.ID, "rotated", detail)
+		if err := database.DeleteWhitelistIP(entry.ID); err != nil {
+			return err
+		}
+		if err := iptables.RemoveIPFromWhitelist(entry.ID); err != nil {
+			log.Printf("Error removing IP from iptables: %v", err)
+		}
+		log.Printf("Revoked whitelist entry %d (%s) of user %s: %s", entry.ID, entry.IP, entry.UserName, detail)
+		notify.Send("whitelist.rotated",
+			fmt.Sprintf("Revoked %s of user %s: more than %d concurrent IP(s), newest is %s",
+				entry.IP, entry.UserName, config.MaxIPsPerUser, current), entry)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package access
+
+import (
+	"errors"
+
+	"iptables-safe/models"
+)
+
+// ErrServiceNotAllowed 表示用户没有被授权解锁该服务
+var ErrServiceNotAllowed = errors.New("service not allowed for this user")
+
+// UserPolicy 返回用户通过SSH或DNS解锁 serviceID 时使用的策略。
+// 这两种方式不校验服务密码，只能解锁管理员为该用户勾选的服务
+func UserPolicy(user *models.User, serviceID int) (*Policy, error) {
+	allowed := false
+	for _, id := range user.ServiceIDs {
+		if id == serviceID {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, ErrServiceNotAllowed
+	}
+	return ResolvePolicy(serviceID)
+}
@@ -129,7 +129,18 @@ if command -v iptables-save &> /dev/null; then
     iptables-save > "$BACKUP_FILE" 2>/dev/null && echo "已备份现有规则到: $BACKUP_FILE"
 fi
 
-# 配置防火墙规则（只开放22和8888端口）
+# 写入基础规则配置：程序启动时按 firewall.json 重建规则，SSH需要写在这里才能保持开放。
+# 已有配置时保留
+if [ ! -f "$INSTALL_DIR/firewall.json" ]; then
+    cat > "$INSTALL_DIR/firewall.json" <<'JSON'
+{
+    "public_ports": ["tcp/22", "tcp/8888"]
+}
+JSON
+    echo "已写入 $INSTALL_DIR/firewall.json（开放22和8888端口）"
+fi
+
+# 程序启动前的临时规则，与 firewall.json 保持一致（只开放22和8888端口）
 echo "配置防火墙规则（只开放22和8888端口）..."
 iptables -F
 iptables -X
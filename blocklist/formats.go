@@ -0,0 +1,139 @@
+package blocklist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// 支持的列表格式
+const (
+	FormatSpamhaus = "spamhaus" // Spamhaus DROP/EDROP，"CIDR ; SBL编号" 或逐行JSON
+	FormatFireHOL  = "firehol"  // FireHOL netset，每行一个IP或CIDR，# 开头为注释
+	FormatPlain    = "plain"    // 每行一个IP或CIDR，# 或 ; 之后为注释
+	FormatTor      = "tor"      // Tor出口列表，每行一个IP或 exit-addresses 中的 "ExitAddress IP ..."
+)
+
+func IsValidFormat(format string) bool {
+	switch format {
+	case FormatSpamhaus, FormatFireHOL, FormatPlain, FormatTor:
+		return true
+	}
+	return false
+}
+
+// reservedNetworks 为永远不会作为公网来源出现的地址段。
+// 部分列表（如 firehol_level1）包含这些网段，导入后会封锁回环和局域网，因此跳过与其重叠的条目
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/3",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// Parse 按格式解析列表，返回去重并排序后的IPv4网段（单个地址为 /32）。
+// 无法识别的行、IPv6地址和保留地址段会被跳过，skipped 为跳过的条目数
+func Parse(format string, r io.Reader) (entries []string, skipped int, err error) {
+	if !IsValidFormat(format) {
+		return nil, 0, fmt.Errorf("unknown format %q", format)
+	}
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		token, ok := lineToken(format, strings.TrimSpace(scanner.Text()))
+		if !ok {
+			continue
+		}
+		network, ok := parseNetwork(token)
+		if !ok || isReserved(network) {
+			skipped++
+			continue
+		}
+		seen[network.String()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	entries = make([]string, 0, len(seen))
+	for entry := range seen {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return entries, skipped, nil
+}
+
+// lineToken 取出一行中的地址部分，注释行和空行返回false
+func lineToken(format, line string) (string, bool) {
+	if line == "" || line[0] == '#' || line[0] == ';' {
+		return "", false
+	}
+
+	switch format {
+	case FormatSpamhaus:
+		// 新版 drop_v4.json 每行一个对象，最后一行为 {"type":"metadata",...}
+		if line[0] == '{' {
+			var item struct {
+				CIDR string `json:"cidr"`
+			}
+			if json.Unmarshal([]byte(line), &item) != nil || item.CIDR == "" {
+				return "", false
+			}
+			return item.CIDR, true
+		}
+	case FormatTor:
+		fields := strings.Fields(line)
+		if fields[0] == "ExitAddress" && len(fields) > 1 {
+			return fields[1], true
+		}
+		// exit-addresses 中的 ExitNode、Published 等行
+		if len(fields) > 1 {
+			return "", false
+		}
+	}
+
+	if i := strings.IndexAny(line, "#;"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	return fields[0], true
+}
+
+func parseNetwork(s string) (*net.IPNet, bool) {
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	ip, network, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() == nil {
+		return nil, false
+	}
+	return network, true
+}
+
+func isReserved(network *net.IPNet) bool {
+	for _, reserved := range reservedNetworks {
+		if reserved.Contains(network.IP) || network.Contains(reserved.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package blocklist
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+)
+
+const (
+	fetchTimeout = 60 * time.Second
+	// maxListSize 为单个列表文件的大小上限
+	maxListSize = 64 << 20
+)
+
+var httpClient = &http.Client{Timeout: fetchTimeout}
+
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// open 打开本地文件或下载URL。下载需要在出站规则中放行列表所在的服务器
+func open(location string) (io.ReadCloser, error) {
+	if !IsURL(location) {
+		return os.Open(location)
+	}
+
+	resp, err := httpClient.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Load 读取并解析列表
+func Load(source models.BlocklistSource) ([]string, int, error) {
+	body, err := open(source.Location)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+
+	return Parse(source.Format, io.LimitReader(body, maxListSize))
+}
+
+// Refresh 重新导入列表到ipset并记录结果。导入失败时ipset保留上次的内容
+func Refresh(source models.BlocklistSource) error {
+	now := time.Now()
+	entries, skipped, err := Load(source)
+	if err == nil && len(entries) == 0 {
+		err = fmt.Errorf("no entries found, check the format")
+	}
+	if err == nil {
+		err = iptables.ReplaceBlocklistSet(source.ID, entries)
+	}
+	if err != nil {
+		database.RecordBlocklistFailure(source.ID, err.Error(), now)
+		return fmt.Errorf("failed to refresh blocklist %s: %v", source.Name, err)
+	}
+
+	if err := database.RecordBlocklistSuccess(source.ID, len(entries), now); err != nil {
+		return err
+	}
+	log.Printf("Blocklist %s loaded %d entries (%d skipped)", source.Name, len(entries), skipped)
+	return nil
+}
+
+// RefreshDue 刷新所有已到刷新时间的启用列表
+func RefreshDue() error {
+	sources, err := database.GetAllBlocklistSources()
+	if err != nil {
+		return fmt.Errorf("failed to get blocklists: %v", err)
+	}
+
+	now := time.Now()
+	for _, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+		interval := time.Duration(source.RefreshMinutes) * time.Minute
+		if source.LastCheckedAt != nil && now.Sub(*source.LastCheckedAt) < interval {
+			continue
+		}
+		if err := Refresh(source); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return nil
+}
+
+// Reconcile 为启用的列表恢复防火墙规则，停用或删除的列表规则会被移除。
+// 系统重启后ipset为空，这些列表不等刷新周期立即重新导入
+func Reconcile() error {
+	sources, err := database.GetAllBlocklistSources()
+	if err != nil {
+		return fmt.Errorf("failed to get blocklists: %v", err)
+	}
+
+	var enabled []int
+	var reload []models.BlocklistSource
+	for _, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+		enabled = append(enabled, source.ID)
+		if iptables.BlocklistSetEmpty(source.ID) {
+			reload = append(reload, source)
+		}
+	}
+	if err := iptables.ReconcileBlocklists(enabled); err != nil {
+		return err
+	}
+
+	for _, source := range reload {
+		log.Printf("Blocklist %s is empty, reloading", source.Name)
+		if err := Refresh(source); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return nil
+}
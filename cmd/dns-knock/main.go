@@ -0,0 +1,67 @@
+// dns-knock 通过DNS查询向iptables-safe解锁，用法：
+//
+//	dns-knock -zone knock.example.com -secret <密钥> [-service 名称] [-resolver 8.8.8.8:53]
+//
+// 密钥可通过 -secret 或环境变量 IPTABLES_SAFE_DNS_SECRET 提供。
+// 加 -print 只打印要查询的域名，可在没有本工具的机器上用 dig/nslookup 查询
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"iptables-safe/dnsknock"
+)
+
+func main() {
+	zone := flag.String("zone", "", "DNS解锁使用的域名，如 knock.example.com")
+	secretHex := flag.String("secret", os.Getenv("IPTABLES_SAFE_DNS_SECRET"), "DNS解锁密钥（64位十六进制）")
+	service := flag.String("service", "", "要解锁的服务名称，留空表示默认登录")
+	resolver := flag.String("resolver", "", "使用的DNS服务器（IP:端口），留空使用系统配置")
+	printOnly := flag.Bool("print", false, "只打印要查询的域名")
+	flag.Parse()
+
+	if *zone == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	secret, err := dnsknock.ParseSecret(*secretHex)
+	if err != nil {
+		fail(err)
+	}
+	name := dnsknock.QueryName(secret, *service, *zone, time.Now())
+	if *printOnly {
+		fmt.Println(name)
+		return
+	}
+
+	r := net.DefaultResolver
+	if *resolver != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, *resolver)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	records, err := r.LookupTXT(ctx, name)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Println(strings.Join(records, "\n"))
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "dns-knock:", err)
+	os.Exit(1)
+}
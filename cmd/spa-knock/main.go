@@ -0,0 +1,57 @@
+// spa-knock 向iptables-safe发送一个单包授权请求，用法：
+//
+//	spa-knock -server 203.0.113.10 -port 62201 -key <密钥> -ip 本机公网IP
+//
+// 密钥可通过 -key 或环境变量 IPTABLES_SAFE_SPA_KEY 提供。SPA只能解锁默认登录
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"iptables-safe/spa"
+)
+
+func main() {
+	server := flag.String("server", "", "服务器地址")
+	port := flag.Int("port", 62201, "服务器的SPA端口")
+	keyHex := flag.String("key", os.Getenv("IPTABLES_SAFE_SPA_KEY"), "SPA密钥（64位十六进制）")
+	ip := flag.String("ip", "", "本机的公网IP，数据包只能为该地址解锁")
+	flag.Parse()
+
+	if *server == "" || net.ParseIP(*ip) == nil {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	key, err := spa.ParseKey(*keyHex)
+	if err != nil {
+		fail(err)
+	}
+	req, err := spa.NewRequest("", *ip)
+	if err != nil {
+		fail(err)
+	}
+	packet, err := spa.Seal(key, req)
+	if err != nil {
+		fail(err)
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(*server, strconv.Itoa(*port)))
+	if err != nil {
+		fail(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(packet); err != nil {
+		fail(err)
+	}
+	fmt.Println("SPA packet sent. The server does not reply; try connecting in a few seconds.")
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "spa-knock:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,188 @@
+// Package config 读取 firewall.json：基础防火墙规则之外，还包含内置解锁服务和外部集成的设置
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"iptables-safe/iptables"
+)
+
+// Config 为 firewall.json 的全部内容。基础规则的字段与其他设置位于同一层，
+// 只有基础规则交给防火墙层，其余设置由 main 分发给各服务
+type Config struct {
+	iptables.BasePolicy
+
+	// DNSResolver 解析域名出站条目使用的DNS服务器（IP或IP:端口），为空时读取 /etc/resolv.conf
+	DNSResolver string `json:"dns_resolver"`
+	// GeoIPDatabase 本地GeoIP国家数据库的路径（.mmdb 或 .csv），为空时不查询国家
+	GeoIPDatabase string `json:"geoip_database"`
+	// SPAPort 单包授权监听的UDP端口，0表示不启用。该端口会自动对所有来源开放
+	SPAPort int `json:"spa_port"`
+	// SSHKnockPort 内置SSH解锁服务监听的TCP端口，0表示不启用。该端口会自动对所有来源开放
+	SSHKnockPort int `json:"ssh_knock_port"`
+	// SSHHostKey SSH解锁服务的主机私钥路径，为空时使用 ./ssh_host_ed25519_key，不存在时自动生成
+	SSHHostKey string `json:"ssh_host_key"`
+	// DNSKnockPort 内置DNS解锁服务监听的UDP端口（通常为53），0表示不启用。该端口会自动对所有来源开放
+	DNSKnockPort int `json:"dns_knock_port"`
+	// DNSKnockZone DNS解锁服务负责的域名，如 knock.example.com，需要在上级域名中把它NS委派到本机
+	DNSKnockZone string `json:"dns_knock_zone"`
+	// NotifyWebhook 接收通知（如新的访问申请）的URL，事件以JSON POST发送，为空时不发送。
+	// 需要为该地址添加出站规则
+	NotifyWebhook string `json:"notify_webhook"`
+	// TrustedProxies 反向代理的地址或网段。只有来自这些地址的请求才按 X-Forwarded-For / X-Real-IP
+	// 确定客户端地址，为空时总是使用连接的对端地址
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// Default 返回未提供配置文件时使用的配置：默认基础规则，不启用任何可选服务
+func Default() *Config {
+	return &Config{BasePolicy: *iptables.DefaultBasePolicy()}
+}
+
+// Load 从JSON文件读取配置，文件不存在时使用默认配置
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// servicePort 为内置服务监听并对所有来源开放的端口
+type servicePort struct {
+	name     string
+	protocol string
+	port     int
+}
+
+func (c *Config) servicePorts() []servicePort {
+	var ports []servicePort
+	if c.SPAPort > 0 {
+		ports = append(ports, servicePort{"spa_port", "udp", c.SPAPort})
+	}
+	if c.SSHKnockPort > 0 {
+		ports = append(ports, servicePort{"ssh_knock_port", "tcp", c.SSHKnockPort})
+	}
+	if c.DNSKnockPort > 0 {
+		ports = append(ports, servicePort{"dns_knock_port", "udp", c.DNSKnockPort})
+	}
+	return ports
+}
+
+// Validate 检查基础规则和各项设置。内置服务的端口不能互相重叠，也不能落在 public_ports 中，
+// 否则同一端口会被两个程序监听或在关闭服务后仍对外开放
+func (c *Config) Validate() error {
+	if err := c.BasePolicy.Validate(); err != nil {
+		return err
+	}
+
+	for _, p := range []struct {
+		name string
+		port int
+	}{{"spa_port", c.SPAPort}, {"ssh_knock_port", c.SSHKnockPort}, {"dns_knock_port", c.DNSKnockPort}} {
+		if p.port < 0 || p.port > 65535 {
+			return fmt.Errorf("%s: invalid port %d", p.name, p.port)
+		}
+	}
+
+	public, _ := iptables.ParsePorts(strings.Join(c.PublicPorts, ","))
+	services := c.servicePorts()
+	for i, s := range services {
+		for _, rule := range public {
+			if rule.Matches(s.protocol, s.port) {
+				return fmt.Errorf("%s: port %d is already opened by public_ports (%s)", s.name, s.port, rule)
+			}
+		}
+		for _, other := range services[:i] {
+			if other.protocol == s.protocol && other.port == s.port {
+				return fmt.Errorf("%s: port %d is already used by %s", s.name, s.port, other.name)
+			}
+		}
+	}
+
+	if c.DNSKnockPort > 0 && !isValidZone(c.DNSKnockZone) {
+		return fmt.Errorf("dns_knock_zone: invalid domain %q", c.DNSKnockZone)
+	}
+
+	if c.NotifyWebhook != "" {
+		u, err := url.Parse(c.NotifyWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("notify_webhook: invalid URL %q", c.NotifyWebhook)
+		}
+	}
+
+	for i, proxy := range c.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("trusted_proxies[%d]: invalid address %q", i, proxy)
+			}
+		}
+	}
+
+	if c.DNSResolver != "" {
+		host := c.DNSResolver
+		if h, _, err := net.SplitHostPort(c.DNSResolver); err == nil {
+			host = h
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("dns_resolver: invalid address %q", c.DNSResolver)
+		}
+	}
+	return nil
+}
+
+// FirewallPolicy 返回交给防火墙层的基础规则：在配置的规则之外开放内置服务的端口，
+// 并在DNS服务器不使用53端口时放行对它的查询
+func (c *Config) FirewallPolicy() *iptables.BasePolicy {
+	policy := c.BasePolicy
+	policy.PublicPorts = append([]string{}, c.PublicPorts...)
+	for _, s := range c.servicePorts() {
+		policy.PublicPorts = append(policy.PublicPorts, s.protocol+"/"+strconv.Itoa(s.port))
+	}
+
+	policy.Outbound = append([]iptables.OutboundRule{}, c.Outbound...)
+	if host, port, err := net.SplitHostPort(c.DNSResolver); err == nil && port != "53" {
+		policy.Outbound = append(policy.Outbound, iptables.OutboundRule{
+			Destination: host,
+			Ports:       []string{"udp/" + port, "tcp/" + port},
+		})
+	}
+	return &policy
+}
+
+// isValidZone 检查域名是否由合法的标签组成且至少包含两级
+func isValidZone(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	labels := strings.Split(s, ".")
+	if len(s) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePortConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"services on distinct ports", func(c *Config) {
+			c.SPAPort, c.SSHKnockPort, c.DNSKnockPort, c.DNSKnockZone = 62201, 2222, 53, "knock.example.com"
+		}, ""},
+		{"same number on different protocols", func(c *Config) {
+			c.SPAPort, c.SSHKnockPort = 2222, 2222
+		}, ""},
+		{"spa in public range", func(c *Config) {
+			c.PublicPorts = []string{"tcp/8888", "udp/60000:65000"}
+			c.SPAPort = 62201
+		}, "spa_port"},
+		{"ssh knock on public port", func(c *Config) {
+			c.SSHKnockPort = 8888
+		}, "ssh_knock_port"},
+		{"dns knock shares spa port", func(c *Config) {
+			c.SPAPort, c.DNSKnockPort, c.DNSKnockZone = 53, 53, "knock.example.com"
+		}, "dns_knock_port"},
+		{"invalid port", func(c *Config) {
+			c.SSHKnockPort = 70000
+		}, "ssh_knock_port"},
+	}
+	for _, tt := range tests {
+		c := Default()
+		tt.modify(c)
+		err := c.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %s error", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFirewallPolicyOpensServicePorts(t *testing.T) {
+	c := Default()
+	c.SPAPort, c.SSHKnockPort = 62201, 2222
+	c.DNSResolver = "127.0.0.1:5353"
+
+	policy := c.FirewallPolicy()
+	if got := strings.Join(policy.PublicPorts, ","); got != "tcp/8888,udp/62201,tcp/2222" {
+		t.Errorf("public ports are %s", got)
+	}
+	if len(c.PublicPorts) != 1 {
+		t.Errorf("configured public ports were modified: %v", c.PublicPorts)
+	}
+	last := policy.Outbound[len(policy.Outbound)-1]
+	if last.Destination != "127.0.0.1" || strings.Join(last.Ports, ",") != "udp/5353,tcp/5353" {
+		t.Errorf("resolver rule is %+v", last)
+	}
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"iptables-safe/models"
+)
+
+func TestFailedAttemptsIgnoreIncompleteKnocks(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "attempts.db")); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	defer DB.Close()
+
+	for _, method := range []string{"password", "knock_incomplete", "knock_incomplete", "link"} {
+		if err := RecordLoginAttempt(models.LoginAttempt{IP: "192.0.2.20", Method: method}); err != nil {
+			t.Fatalf("failed to record attempt: %v", err)
+		}
+	}
+
+	failed, err := GetRecentFailedAttempts("192.0.2.20", 0, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to count attempts: %v", err)
+	}
+	if failed != 2 {
+		t.Errorf("got %d failed attempts, want 2", failed)
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const banSelect = `SELECT id, ip, level, reason, created_at, expires_at, lifted_at FROM bans`
+
+func scanBan(row rowScanner) (models.Ban, error) {
+	var b models.Ban
+	var liftedAt sql.NullTime
+	err := row.Scan(&b.ID, &b.IP, &b.Level, &b.Reason, &b.CreatedAt, &b.ExpiresAt, &liftedAt)
+	if err != nil {
+		return b, err
+	}
+	if liftedAt.Valid {
+		b.LiftedAt = &liftedAt.Time
+	}
+	return b, nil
+}
+
+func queryBans(query string, args ...interface{}) ([]models.Ban, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []models.Ban
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// RecordLockout 记录一次因登录失败次数达到上限而触发的锁定
+func RecordLockout(ip string, serviceID int) error {
+	_, err := DB.Exec("INSERT INTO lockouts (ip, service_id, created_at) VALUES (?, ?, ?)", ip, serviceID, time.Now())
+	return err
+}
+
+// HasLockoutSince 返回IP自 since 之后是否已因某个服务被锁定过
+func HasLockoutSince(ip string, serviceID int, since time.Time) (bool, error) {
+	var count int
+	err := DB.QueryRow(
+		"SELECT COUNT(*) FROM lockouts WHERE ip = ? AND service_id = ? AND created_at > ?",
+		ip, serviceID, since,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// CountLockouts 返回IP自 since 之后被锁定的次数（不区分服务）
+func CountLockouts(ip string, since time.Time) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM lockouts WHERE ip = ? AND created_at > ?", ip, since).Scan(&count)
+	return count, err
+}
+
+func CleanupOldLockouts(before time.Time) error {
+	_, err := DB.Exec("DELETE FROM lockouts WHERE created_at < ?", before)
+	return err
+}
+
+// GetActiveBans 返回未过期且未解封的封禁
+func GetActiveBans() ([]models.Ban, error) {
+	return queryBans(banSelect+" WHERE lifted_at IS NULL AND expires_at > ? ORDER BY id", time.Now())
+}
+
+// GetRecentBans 返回自 since 之后创建的封禁，包括已过期和已解封的
+func GetRecentBans(since time.Time) ([]models.Ban, error) {
+	return queryBans(banSelect+" WHERE created_at > ? ORDER BY id DESC", since)
+}
+
+// GetLatestBan 返回IP最近一次封禁，没有时返回 sql.ErrNoRows
+func GetLatestBan(ip string) (*models.Ban, error) {
+	b, err := scanBan(DB.QueryRow(banSelect+" WHERE ip = ? ORDER BY id DESC LIMIT 1", ip))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func GetBan(id int) (*models.Ban, error) {
+	b, err := scanBan(DB.QueryRow(banSelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// CountRecentBans 返回IP自 since 之后未被管理员解封的封禁次数，用于计算下一次封禁的级别
+func CountRecentBans(ip string, since time.Time) (int, error) {
+	var count int
+	err := DB.QueryRow(
+		"SELECT COUNT(*) FROM bans WHERE ip = ? AND created_at > ? AND lifted_at IS NULL",
+		ip, since,
+	).Scan(&count)
+	return count, err
+}
+
+func AddBan(b models.Ban) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		"INSERT INTO bans (ip, level, reason, created_at, expires_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		b.IP, b.Level, b.Reason, b.CreatedAt, b.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// LiftBan 记录管理员解封，解封过的封禁不计入之后的升级
+func LiftBan(id int) error {
+	_, err := DB.Exec("UPDATE bans SET lifted_at = ? WHERE id = ?", time.Now(), id)
+	return err
+}
+
+// UpdateBanPolicy 设置自动封禁的阈值和时长
+func UpdateBanPolicy(c models.Config) error {
+	_, err := DB.Exec(
+		`UPDATE config SET ban_after_lockouts = ?, ban_window_minutes = ?, ban_base_minutes = ?,
+		ban_max_minutes = ? WHERE id = 1`,
+		c.BanAfterLockouts, c.BanWindowMinutes, c.BanBaseMinutes, c.BanMaxMinutes,
+	)
+	return err
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const blocklistSelect = `SELECT id, name, format, location, refresh_minutes, enabled, entry_count,
+	last_checked_at, last_updated_at, last_error, created_at FROM blocklist_sources`
+
+func scanBlocklistSource(row rowScanner) (models.BlocklistSource, error) {
+	var b models.BlocklistSource
+	var checkedAt, updatedAt sql.NullTime
+	err := row.Scan(&b.ID, &b.Name, &b.Format, &b.Location, &b.RefreshMinutes, &b.Enabled, &b.EntryCount,
+		&checkedAt, &updatedAt, &b.LastError, &b.CreatedAt)
+	if err != nil {
+		return b, err
+	}
+	if checkedAt.Valid {
+		b.LastCheckedAt = &checkedAt.Time
+	}
+	if updatedAt.Valid {
+		b.LastUpdatedAt = &updatedAt.Time
+	}
+	return b, nil
+}
+
+func GetAllBlocklistSources() ([]models.BlocklistSource, error) {
+	rows, err := DB.Query(blocklistSelect + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []models.BlocklistSource
+	for rows.Next() {
+		b, err := scanBlocklistSource(rows)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, b)
+	}
+	return sources, rows.Err()
+}
+
+func GetBlocklistSource(id int) (*models.BlocklistSource, error) {
+	b, err := scanBlocklistSource(DB.QueryRow(blocklistSelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func AddBlocklistSource(b models.BlocklistSource) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO blocklist_sources (name, format, location, refresh_minutes, enabled)
+		VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		b.Name, b.Format, b.Location, b.RefreshMinutes, b.Enabled,
+	).Scan(&id)
+	return id, err
+}
+
+func UpdateBlocklistSource(b models.BlocklistSource) error {
+	_, err := DB.Exec(
+		`UPDATE blocklist_sources SET name = ?, format = ?, location = ?, refresh_minutes = ?, enabled = ?
+		WHERE id = ?`,
+		b.Name, b.Format, b.Location, b.RefreshMinutes, b.Enabled, b.ID,
+	)
+	return err
+}
+
+func DeleteBlocklistSource(id int) error {
+	_, err := DB.Exec("DELETE FROM blocklist_sources WHERE id = ?", id)
+	return err
+}
+
+// RecordBlocklistSuccess 记录一次成功导入
+func RecordBlocklistSuccess(id, count int, at time.Time) error {
+	_, err := DB.Exec(
+		`UPDATE blocklist_sources SET entry_count = ?, last_checked_at = ?, last_updated_at = ?, last_error = ''
+		WHERE id = ?`,
+		count, at, at, id,
+	)
+	return err
+}
+
+// RecordBlocklistFailure 记录一次失败的导入，ipset保留上次成功导入的内容
+func RecordBlocklistFailure(id int, message string, at time.Time) error {
+	_, err := DB.Exec("UPDATE blocklist_sources SET last_checked_at = ?, last_error = ? WHERE id = ?", at, message, id)
+	return err
+}
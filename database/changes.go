@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const pendingChangeSelect = `SELECT id, kind, summary, payload, status, requested_by, requested_session,
+	created_at, expires_at, decided_at, decided_by, note FROM pending_changes`
+
+func scanPendingChange(row rowScanner) (models.PendingChange, error) {
+	var c models.PendingChange
+	var payload string
+	var decidedAt sql.NullTime
+	err := row.Scan(&c.ID, &c.Kind, &c.Summary, &payload, &c.Status, &c.RequestedBy, &c.RequestedSession,
+		&c.CreatedAt, &c.ExpiresAt, &decidedAt, &c.DecidedBy, &c.Note)
+	if err != nil {
+		return c, err
+	}
+	c.Payload = []byte(payload)
+	if decidedAt.Valid {
+		c.DecidedAt = &decidedAt.Time
+	}
+	return c, nil
+}
+
+// GetPendingChanges 返回待审批的变更和 since 之后提交的其他变更，最新的在前
+func GetPendingChanges(since time.Time) ([]models.PendingChange, error) {
+	rows, err := DB.Query(pendingChangeSelect+" WHERE status = 'pending' OR created_at > ? ORDER BY id DESC", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.PendingChange
+	for rows.Next() {
+		c, err := scanPendingChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func GetPendingChange(id int) (*models.PendingChange, error) {
+	c, err := scanPendingChange(DB.QueryRow(pendingChangeSelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func AddPendingChange(c models.PendingChange) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO pending_changes (kind, summary, payload, requested_by, requested_session, created_at,
+			expires_at) VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		c.Kind, c.Summary, string(c.Payload), c.RequestedBy, c.RequestedSession, c.CreatedAt, c.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// DecidePendingChange 把待审批的变更改为 status，返回是否修改成功。
+// 只有仍处于待审批状态的变更会被修改，同一变更不会被执行两次
+func DecidePendingChange(id int, status, decidedBy, note string) (bool, error) {
+	result, err := DB.Exec(
+		`UPDATE pending_changes SET status = ?, decided_by = ?, note = ?, decided_at = ?
+			WHERE id = ? AND status = 'pending'`,
+		status, decidedBy, note, time.Now(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ReopenPendingChange 在批准后执行失败时把变更恢复为待审批
+func ReopenPendingChange(id int) error {
+	_, err := DB.Exec(
+		"UPDATE pending_changes SET status = 'pending', decided_by = '', note = '', decided_at = NULL WHERE id = ?", id,
+	)
+	return err
+}
+
+// ExpirePendingChanges 将已到有效期仍未审批的变更标记为过期，返回被标记的变更
+func ExpirePendingChanges(now time.Time) ([]models.PendingChange, error) {
+	rows, err := DB.Query(pendingChangeSelect+" WHERE status = 'pending' AND expires_at < ?", now)
+	if err != nil {
+		return nil, err
+	}
+	var expired []models.PendingChange
+	for rows.Next() {
+		c, err := scanPendingChange(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []models.PendingChange
+	for _, c := range expired {
+		ok, err := DecidePendingChange(c.ID, "expired", "", "")
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			c.Status = "expired"
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
@@ -27,6 +27,10 @@ func InitDB(dbPath string) error {
 		return err
 	}
 
+	if err = migrateTables(); err != nil {
+		return err
+	}
+
 	if err = initDefaultConfig(); err != nil {
 		return err
 	}
@@ -34,20 +38,52 @@ func InitDB(dbPath string) error {
 	return nil
 }
 
-func createTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS whitelist_ips (
+// whitelistColumns 为白名单表结构，同一IP可以按服务分别解锁，
+// service_id 为0表示未关联服务的条目（管理员添加或默认登录）
+const whitelistColumns = `
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			ip TEXT NOT NULL UNIQUE,
+			ip TEXT NOT NULL,
 			description TEXT,
 			is_permanent BOOLEAN DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			expires_at DATETIME
-		)`,
+			expires_at DATETIME,
+			ports TEXT NOT NULL DEFAULT '',
+			service_id INTEGER NOT NULL DEFAULT 0,
+			hostname TEXT NOT NULL DEFAULT '',
+			last_active_at DATETIME,
+			last_reviewed_at DATETIME,
+			country TEXT NOT NULL DEFAULT '',
+			user_id INTEGER NOT NULL DEFAULT 0,
+			granted_at DATETIME,
+			source TEXT NOT NULL DEFAULT '',
+			extend_token TEXT NOT NULL DEFAULT '',
+			UNIQUE(ip, service_id)
+		`
+
+func createTables() error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS whitelist_ips (` + whitelistColumns + `)`,
 		`CREATE TABLE IF NOT EXISTS config (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			user_password TEXT NOT NULL,
-			admin_password TEXT NOT NULL
+			admin_password TEXT NOT NULL,
+			user_ports TEXT NOT NULL DEFAULT '',
+			stale_after_days INTEGER NOT NULL DEFAULT 30,
+			review_interval_days INTEGER NOT NULL DEFAULT 90,
+			ban_after_lockouts INTEGER NOT NULL DEFAULT 3,
+			ban_window_minutes INTEGER NOT NULL DEFAULT 60,
+			ban_base_minutes INTEGER NOT NULL DEFAULT 60,
+			ban_max_minutes INTEGER NOT NULL DEFAULT 10080,
+			country_mode TEXT NOT NULL DEFAULT '',
+			countries TEXT NOT NULL DEFAULT '',
+			knock_sequence TEXT NOT NULL DEFAULT '',
+			spa_key TEXT NOT NULL DEFAULT '',
+			link_key TEXT NOT NULL DEFAULT '',
+			four_eyes_permanent BOOLEAN NOT NULL DEFAULT 0,
+			approver_password TEXT NOT NULL DEFAULT '',
+			pending_ttl_hours INTEGER NOT NULL DEFAULT 24,
+			user_max_duration_minutes INTEGER NOT NULL DEFAULT 1440,
+			max_ips_per_user INTEGER NOT NULL DEFAULT 0
 		)`,
 		`CREATE TABLE IF NOT EXISTS login_attempts (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -56,6 +92,166 @@ func createTables() error {
 			success BOOLEAN DEFAULT 0
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip, timestamp)`,
+		`CREATE TABLE IF NOT EXISTS services (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			ports TEXT NOT NULL DEFAULT '',
+			password TEXT NOT NULL,
+			max_duration_minutes INTEGER NOT NULL DEFAULT 1440,
+			max_failed_attempts INTEGER NOT NULL DEFAULT 5,
+			lockout_minutes INTEGER NOT NULL DEFAULT 15,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS whitelist_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_whitelist_history_entry ON whitelist_history(entry_id, id)`,
+		`CREATE TABLE IF NOT EXISTS review_decisions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			entry_id INTEGER NOT NULL,
+			ip TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			decision TEXT NOT NULL,
+			reasons TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT '',
+			admin_ip TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS whitelist_traffic (
+			entry_id INTEGER NOT NULL,
+			sampled_at DATETIME NOT NULL,
+			packets_in INTEGER NOT NULL DEFAULT 0,
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			packets_out INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_whitelist_traffic_entry ON whitelist_traffic(entry_id, sampled_at)`,
+		`CREATE TABLE IF NOT EXISTS deny_list (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			address TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS lockouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip TEXT NOT NULL,
+			service_id INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_lockouts_ip ON lockouts(ip, created_at)`,
+		`CREATE TABLE IF NOT EXISTS bans (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip TEXT NOT NULL,
+			level INTEGER NOT NULL DEFAULT 0,
+			reason TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			lifted_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_bans_ip ON bans(ip, created_at)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			dns_secret TEXT NOT NULL DEFAULT '',
+			service_ids TEXT NOT NULL DEFAULT '0',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS ssh_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			public_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL UNIQUE,
+			comment TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_ssh_keys_user ON ssh_keys(user_id)`,
+		`CREATE TABLE IF NOT EXISTS access_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			description TEXT NOT NULL DEFAULT '',
+			service_ids TEXT NOT NULL DEFAULT '0',
+			max_uses INTEGER NOT NULL DEFAULT 1,
+			uses INTEGER NOT NULL DEFAULT 0,
+			duration_minutes INTEGER NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			revoked_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS access_link_uses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			link_id INTEGER NOT NULL,
+			ip TEXT NOT NULL,
+			service_id INTEGER NOT NULL DEFAULT 0,
+			country TEXT NOT NULL DEFAULT '',
+			success BOOLEAN NOT NULL DEFAULT 0,
+			detail TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_access_link_uses_link ON access_link_uses(link_id)`,
+		`CREATE TABLE IF NOT EXISTS access_requests (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL,
+			country TEXT NOT NULL DEFAULT '',
+			service_id INTEGER NOT NULL DEFAULT 0,
+			duration_minutes INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			note TEXT NOT NULL DEFAULT '',
+			token TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL,
+			decided_at DATETIME,
+			expires_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_access_requests_status ON access_requests(status, created_at)`,
+		`CREATE TABLE IF NOT EXISTS pending_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			summary TEXT NOT NULL DEFAULT '',
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			requested_by TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			decided_at DATETIME,
+			decided_by TEXT NOT NULL DEFAULT '',
+			note TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_pending_changes_status ON pending_changes(status, created_at)`,
+		`CREATE TABLE IF NOT EXISTS blocklist_sources (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			format TEXT NOT NULL,
+			location TEXT NOT NULL,
+			refresh_minutes INTEGER NOT NULL DEFAULT 1440,
+			enabled BOOLEAN NOT NULL DEFAULT 1,
+			entry_count INTEGER NOT NULL DEFAULT 0,
+			last_checked_at DATETIME,
+			last_updated_at DATETIME,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS egress_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			destination TEXT NOT NULL,
+			ports TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS egress_addresses (
+			egress_id INTEGER NOT NULL,
+			address TEXT NOT NULL,
+			ttl_seconds INTEGER NOT NULL DEFAULT 0,
+			resolved_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			PRIMARY KEY (egress_id, address)
+		)`,
 	}
 
 	for _, query := range queries {
@@ -67,6 +263,116 @@ func createTables() error {
 	return nil
 }
 
+// migrationColumns 为旧版本之后新增的列。whitelist_ips 的列还必须出现在 whitelistColumns 中，
+// 否则从最早版本迁移时会在 rebuildWhitelistTable 中丢失
+var migrationColumns = []struct {
+	table, column, definition string
+}{
+	{"whitelist_ips", "ports", "TEXT NOT NULL DEFAULT ''"},
+	{"config", "user_ports", "TEXT NOT NULL DEFAULT ''"},
+	{"login_attempts", "service_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"whitelist_ips", "hostname", "TEXT NOT NULL DEFAULT ''"},
+	{"whitelist_ips", "last_active_at", "DATETIME"},
+	{"whitelist_ips", "last_reviewed_at", "DATETIME"},
+	{"config", "stale_after_days", "INTEGER NOT NULL DEFAULT 30"},
+	{"config", "review_interval_days", "INTEGER NOT NULL DEFAULT 90"},
+	{"config", "ban_after_lockouts", "INTEGER NOT NULL DEFAULT 3"},
+	{"config", "ban_window_minutes", "INTEGER NOT NULL DEFAULT 60"},
+	{"config", "ban_base_minutes", "INTEGER NOT NULL DEFAULT 60"},
+	{"config", "ban_max_minutes", "INTEGER NOT NULL DEFAULT 10080"},
+	{"config", "country_mode", "TEXT NOT NULL DEFAULT ''"},
+	{"config", "countries", "TEXT NOT NULL DEFAULT ''"},
+	{"whitelist_ips", "country", "TEXT NOT NULL DEFAULT ''"},
+	{"login_attempts", "country", "TEXT NOT NULL DEFAULT ''"},
+	{"config", "knock_sequence", "TEXT NOT NULL DEFAULT ''"},
+	{"login_attempts", "method", "TEXT NOT NULL DEFAULT 'password'"},
+	{"config", "spa_key", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "dns_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"config", "link_key", "TEXT NOT NULL DEFAULT ''"},
+	{"config", "four_eyes_permanent", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"config", "approver_password", "TEXT NOT NULL DEFAULT ''"},
+	{"config", "pending_ttl_hours", "INTEGER NOT NULL DEFAULT 24"},
+	{"config", "user_max_duration_minutes", "INTEGER NOT NULL DEFAULT 1440"},
+	{"whitelist_ips", "user_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"whitelist_ips", "granted_at", "DATETIME"},
+	{"config", "max_ips_per_user", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "service_ids", "TEXT NOT NULL DEFAULT '0'"},
+	{"config", "four_eyes_max_hours", "INTEGER NOT NULL DEFAULT 168"},
+	{"config", "four_eyes_min_prefix", "INTEGER NOT NULL DEFAULT 24"},
+	{"pending_changes", "requested_session", "TEXT NOT NULL DEFAULT ''"},
+	{"whitelist_ips", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"whitelist_ips", "extend_token", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrateTables 为旧版本创建的数据库补齐新增的列
+func migrateTables() error {
+	for _, col := range migrationColumns {
+		if err := addColumnIfMissing(col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+
+	return rebuildWhitelistTable()
+}
+
+// rebuildWhitelistTable 将旧版本以IP唯一的白名单表重建为按(IP, 服务)唯一，
+// 条目ID保持不变，已插入的iptables规则仍然有效
+func rebuildWhitelistTable() error {
+	exists, err := hasColumn("whitelist_ips", "service_id")
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`CREATE TABLE whitelist_ips_new (` + whitelistColumns + `)`,
+		`INSERT INTO whitelist_ips_new (id, ip, description, is_permanent, created_at, expires_at, ports)
+			SELECT id, ip, description, is_permanent, created_at, expires_at, ports FROM whitelist_ips`,
+		`DROP TABLE whitelist_ips`,
+		`ALTER TABLE whitelist_ips_new RENAME TO whitelist_ips`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func hasColumn(table, column string) (bool, error) {
+	rows, err := DB.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func addColumnIfMissing(table, column, definition string) error {
+	exists, err := hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = DB.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 func initDefaultConfig() error {
 	var count int
 	err := DB.QueryRow("SELECT COUNT(*) FROM config").Scan(&count)
@@ -97,8 +403,19 @@ func initDefaultConfig() error {
 
 func GetConfig() (*models.Config, error) {
 	config := &models.Config{}
-	err := DB.QueryRow("SELECT id, user_password, admin_password FROM config LIMIT 1").
-		Scan(&config.ID, &config.UserPassword, &config.AdminPassword)
+	err := DB.QueryRow(`SELECT id, user_password, admin_password, user_ports, stale_after_days,
+		review_interval_days, ban_after_lockouts, ban_window_minutes, ban_base_minutes, ban_max_minutes,
+		country_mode, countries, knock_sequence, spa_key, link_key, four_eyes_permanent,
+		approver_password, pending_ttl_hours, user_max_duration_minutes, max_ips_per_user,
+		four_eyes_max_hours, four_eyes_min_prefix
+		FROM config LIMIT 1`).
+		Scan(&config.ID, &config.UserPassword, &config.AdminPassword, &config.UserPorts,
+			&config.StaleAfterDays, &config.ReviewIntervalDays, &config.BanAfterLockouts,
+			&config.BanWindowMinutes, &config.BanBaseMinutes, &config.BanMaxMinutes,
+			&config.CountryMode, &config.Countries, &config.KnockSequence, &config.SPAKey,
+			&config.LinkKey, &config.FourEyesPermanent, &config.ApproverPassword, &config.PendingTTLHours,
+			&config.UserMaxDurationMinutes, &config.MaxIPsPerUser, &config.FourEyesMaxHours,
+			&config.FourEyesMinPrefix)
 	if err != nil {
 		return nil, err
 	}
@@ -123,16 +440,139 @@ func UpdateAdminPassword(newPassword string) error {
 	return err
 }
 
-func AddWhitelistIP(ip, description string, isPermanent bool, expiresAt time.Time) error {
+// UpdateUserPolicy 设置默认登录放行的端口、可申请的最长授权时长和每个用户同时放行的IP数
+func UpdateUserPolicy(ports string, maxDurationMinutes, maxIPsPerUser int) error {
 	_, err := DB.Exec(
-		"INSERT OR REPLACE INTO whitelist_ips (ip, description, is_permanent, expires_at) VALUES (?, ?, ?, ?)",
-		ip, description, isPermanent, expiresAt,
-	)
+		"UPDATE config SET user_ports = ?, user_max_duration_minutes = ?, max_ips_per_user = ? WHERE id = 1",
+		ports, maxDurationMinutes, maxIPsPerUser)
+	return err
+}
+
+// UpdateReviewPolicy 设置永久条目的复核周期，0表示不检查对应项
+func UpdateReviewPolicy(staleAfterDays, reviewIntervalDays int) error {
+	_, err := DB.Exec("UPDATE config SET stale_after_days = ?, review_interval_days = ? WHERE id = 1",
+		staleAfterDays, reviewIntervalDays)
+	return err
+}
+
+// UpdateApproverPassword 设置审批人密码
+func UpdateApproverPassword(newPassword string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("UPDATE config SET approver_password = ? WHERE id = 1", string(hash))
+	return err
+}
+
+// UpdateApprovalPolicy 设置是否开启双人审批、待审批变更的有效期，
+// 以及无需批准的临时条目最长有效期和网段最短前缀
+func UpdateApprovalPolicy(fourEyesPermanent bool, pendingTTLHours, maxHours, minPrefix int) error {
+	_, err := DB.Exec(
+		`UPDATE config SET four_eyes_permanent = ?, pending_ttl_hours = ?, four_eyes_max_hours = ?,
+			four_eyes_min_prefix = ? WHERE id = 1`,
+		fourEyesPermanent, pendingTTLHours, maxHours, minPrefix)
+	return err
+}
+
+// UpdateCountryPolicy 设置按国家限制登录和解锁的策略，mode 为空表示不限制
+func UpdateCountryPolicy(mode, countries string) error {
+	_, err := DB.Exec("UPDATE config SET country_mode = ?, countries = ? WHERE id = 1", mode, countries)
+	return err
+}
+
+// UpdateKnockSequence 设置端口敲门序列，为空表示关闭敲门
+func UpdateKnockSequence(sequence string) error {
+	_, err := DB.Exec("UPDATE config SET knock_sequence = ? WHERE id = 1", sequence)
 	return err
 }
 
+// UpdateSPAKey 设置单包授权的密钥
+func UpdateSPAKey(key string) error {
+	_, err := DB.Exec("UPDATE config SET spa_key = ? WHERE id = 1", key)
+	return err
+}
+
+// whitelistSelect 为查询白名单条目时使用的列，附带关联服务和用户的名称
+const whitelistSelect = `SELECT w.id, w.ip, w.description, w.is_permanent, w.created_at, w.expires_at,
+	w.ports, w.service_id, COALESCE(s.name, ''), w.hostname, w.last_active_at, w.last_reviewed_at,
+	w.country, w.user_id, COALESCE(u.name, ''), w.granted_at, w.source FROM whitelist_ips w
+	LEFT JOIN services s ON s.id = w.service_id LEFT JOIN users u ON u.id = w.user_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWhitelistIP(row rowScanner) (models.WhitelistIP, error) {
+	var ip models.WhitelistIP
+	var description sql.NullString
+	var expiresAt, lastActiveAt, lastReviewedAt, grantedAt sql.NullTime
+	err := row.Scan(&ip.ID, &ip.IP, &description, &ip.IsPermanent, &ip.CreatedAt, &expiresAt,
+		&ip.Ports, &ip.ServiceID, &ip.ServiceName, &ip.Hostname, &lastActiveAt, &lastReviewedAt, &ip.Country,
+		&ip.UserID, &ip.UserName, &grantedAt, &ip.Source)
+	if err != nil {
+		return ip, err
+	}
+	ip.Description = description.String
+	if expiresAt.Valid {
+		ip.ExpiresAt = expiresAt.Time
+	}
+	if lastActiveAt.Valid {
+		ip.LastActiveAt = &lastActiveAt.Time
+	}
+	if lastReviewedAt.Valid {
+		ip.LastReviewedAt = &lastReviewedAt.Time
+	}
+	ip.GrantedAt = ip.CreatedAt
+	if grantedAt.Valid {
+		ip.GrantedAt = grantedAt.Time
+	}
+	return ip, nil
+}
+
+// heldWhitelistEntry 判断重复添加时是否保留已有条目的描述、端口、来源和归属用户：
+// 已有条目为永久或域名条目，而本次只是一次临时授权
+const heldWhitelistEntry = `(whitelist_ips.is_permanent OR whitelist_ips.hostname != '')
+	AND NOT excluded.is_permanent AND excluded.hostname = ''`
+
+// AddWhitelistIP 新增或更新白名单条目，返回合并后保存的条目。
+// 同一IP对同一服务重复添加时保留原有ID，使已插入的iptables规则仍能对应到该条目。
+// 重复添加不会降级已有条目：永久和域名条目保持不变，过期时间取较晚的一个，
+// 本次未识别用户时保留原有的归属用户
+func AddWhitelistIP(entry models.WhitelistIP) (*models.WhitelistIP, error) {
+	var expiresAt interface{}
+	if !entry.IsPermanent {
+		expiresAt = entry.ExpiresAt
+	}
+
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO whitelist_ips (ip, description, is_permanent, expires_at, ports, service_id, hostname, country,
+			user_id, granted_at, source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(ip, service_id) DO UPDATE SET
+			description = CASE WHEN `+heldWhitelistEntry+` THEN whitelist_ips.description ELSE excluded.description END,
+			ports = CASE WHEN `+heldWhitelistEntry+` THEN whitelist_ips.ports ELSE excluded.ports END,
+			source = CASE WHEN `+heldWhitelistEntry+` THEN whitelist_ips.source ELSE excluded.source END,
+			is_permanent = whitelist_ips.is_permanent OR excluded.is_permanent,
+			expires_at = CASE WHEN whitelist_ips.is_permanent OR excluded.is_permanent THEN NULL
+				ELSE MAX(COALESCE(whitelist_ips.expires_at, excluded.expires_at), excluded.expires_at) END,
+			hostname = CASE WHEN excluded.hostname != '' THEN excluded.hostname ELSE whitelist_ips.hostname END,
+			user_id = CASE WHEN excluded.user_id = 0 OR `+heldWhitelistEntry+` THEN whitelist_ips.user_id
+				ELSE excluded.user_id END,
+			country = excluded.country, granted_at = excluded.granted_at
+		RETURNING id`,
+		entry.IP, entry.Description, entry.IsPermanent, expiresAt, entry.Ports, entry.ServiceID, entry.Hostname,
+		entry.Country, entry.UserID, time.Now(), entry.Source,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return GetWhitelistIP(id)
+}
+
 func GetAllWhitelistIPs() ([]models.WhitelistIP, error) {
-	rows, err := DB.Query("SELECT id, ip, description, is_permanent, created_at, expires_at FROM whitelist_ips")
+	rows, err := DB.Query(whitelistSelect + " ORDER BY w.id")
 	if err != nil {
 		return nil, err
 	}
@@ -140,20 +580,141 @@ func GetAllWhitelistIPs() ([]models.WhitelistIP, error) {
 
 	var ips []models.WhitelistIP
 	for rows.Next() {
-		var ip models.WhitelistIP
-		var expiresAt sql.NullTime
-		err := rows.Scan(&ip.ID, &ip.IP, &ip.Description, &ip.IsPermanent, &ip.CreatedAt, &expiresAt)
+		ip, err := scanWhitelistIP(rows)
 		if err != nil {
 			return nil, err
 		}
-		if expiresAt.Valid {
-			ip.ExpiresAt = expiresAt.Time
-		}
 		ips = append(ips, ip)
 	}
 	return ips, nil
 }
 
+func GetWhitelistIP(id int) (*models.WhitelistIP, error) {
+	ip, err := scanWhitelistIP(DB.QueryRow(whitelistSelect+" WHERE w.id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &ip, nil
+}
+
+func UpdateWhitelistIP(id int, description, ports string) error {
+	_, err := DB.Exec("UPDATE whitelist_ips SET description = ?, ports = ? WHERE id = ?", description, ports, id)
+	return err
+}
+
+// UpdateWhitelistAddress 更新域名条目解析到的地址及其所属国家
+func UpdateWhitelistAddress(id int, ip, country string) error {
+	_, err := DB.Exec("UPDATE whitelist_ips SET ip = ?, country = ? WHERE id = ?", ip, country, id)
+	return err
+}
+
+// GetActiveHostnameEntries 返回未过期的域名白名单条目
+func GetActiveHostnameEntries() ([]models.WhitelistIP, error) {
+	rows, err := DB.Query(whitelistSelect+
+		" WHERE w.hostname != '' AND (w.is_permanent = 1 OR w.expires_at > ?)", time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.WhitelistIP
+	for rows.Next() {
+		entry, err := scanWhitelistIP(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AddWhitelistHistory 记录白名单条目的一次变更
+func AddWhitelistHistory(entryID int, action, detail string) error {
+	_, err := DB.Exec("INSERT INTO whitelist_history (entry_id, action, detail) VALUES (?, ?, ?)", entryID, action, detail)
+	return err
+}
+
+func GetWhitelistHistory(entryID int) ([]models.WhitelistHistory, error) {
+	rows, err := DB.Query(
+		"SELECT id, entry_id, action, detail, created_at FROM whitelist_history WHERE entry_id = ? ORDER BY id DESC",
+		entryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.WhitelistHistory
+	for rows.Next() {
+		var h models.WhitelistHistory
+		if err := rows.Scan(&h.ID, &h.EntryID, &h.Action, &h.Detail, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+// GetActiveWhitelistIPsByAddress 返回该IP未过期的全部白名单条目
+func GetActiveWhitelistIPsByAddress(ip string) ([]models.WhitelistIP, error) {
+	rows, err := DB.Query(whitelistSelect+
+		" WHERE w.ip = ? AND (w.is_permanent = 1 OR w.expires_at > ?) ORDER BY w.id", ip, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []models.WhitelistIP
+	for rows.Next() {
+		entry, err := scanWhitelistIP(rows)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, entry)
+	}
+	return ips, rows.Err()
+}
+
+// GetActiveUserWhitelistIPs 返回用户未过期的临时条目，最近授权的在前
+func GetActiveUserWhitelistIPs(userID int) ([]models.WhitelistIP, error) {
+	rows, err := DB.Query(whitelistSelect+
+		" WHERE w.user_id = ? AND w.is_permanent = 0 AND w.expires_at > ? ORDER BY w.granted_at DESC, w.id DESC",
+		userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []models.WhitelistIP
+	for rows.Next() {
+		entry, err := scanWhitelistIP(rows)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, entry)
+	}
+	return ips, rows.Err()
+}
+
+// SetWhitelistExtendToken 保存条目延长令牌的摘要，令牌本身只由发放授权的浏览器持有
+func SetWhitelistExtendToken(id int, tokenHash string) error {
+	_, err := DB.Exec("UPDATE whitelist_ips SET extend_token = ? WHERE id = ?", tokenHash, id)
+	return err
+}
+
+// GetWhitelistExtendToken 返回条目延长令牌的摘要，未发放时为空
+func GetWhitelistExtendToken(id int) (string, error) {
+	var tokenHash string
+	err := DB.QueryRow("SELECT extend_token FROM whitelist_ips WHERE id = ?", id).Scan(&tokenHash)
+	return tokenHash, err
+}
+
+// ExtendWhitelistIP 修改临时条目的过期时间，永久条目不受影响
+func ExtendWhitelistIP(id int, expiresAt time.Time) error {
+	_, err := DB.Exec("UPDATE whitelist_ips SET expires_at = ? WHERE id = ? AND is_permanent = 0", expiresAt, id)
+	return err
+}
+
 func DeleteWhitelistIP(id int) error {
 	_, err := DB.Exec("DELETE FROM whitelist_ips WHERE id = ?", id)
 	return err
@@ -168,17 +729,45 @@ func IsIPWhitelisted(ip string) (bool, error) {
 	return count > 0, err
 }
 
-func RecordLoginAttempt(ip string, success bool) error {
-	_, err := DB.Exec("INSERT INTO login_attempts (ip, success) VALUES (?, ?)", ip, success)
+// RecordLoginAttempt 记录一次登录尝试，ServiceID 为0表示默认登录，Country 为来源IP所属国家
+func RecordLoginAttempt(attempt models.LoginAttempt) error {
+	_, err := DB.Exec("INSERT INTO login_attempts (ip, service_id, method, success, country) VALUES (?, ?, ?, ?, ?)",
+		attempt.IP, attempt.ServiceID, attempt.Method, attempt.Success, attempt.Country)
 	return err
 }
 
-func GetRecentFailedAttempts(ip string, duration time.Duration) (int, error) {
+// GetRecentLoginAttempts 返回最近的登录尝试，按时间倒序
+func GetRecentLoginAttempts(limit int) ([]models.LoginAttempt, error) {
+	rows, err := DB.Query(
+		`SELECT a.ip, a.timestamp, a.success, a.service_id, COALESCE(s.name, ''), a.method, a.country
+		FROM login_attempts a LEFT JOIN services s ON s.id = a.service_id
+		ORDER BY a.id DESC LIMIT ?`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.IP, &a.Timestamp, &a.Success, &a.ServiceID, &a.ServiceName, &a.Method, &a.Country); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// GetRecentFailedAttempts 返回该IP在 duration 内对服务的失败次数。未完成的敲门（knock_incomplete）
+// 与端口扫描无法区分，只记录不计入
+func GetRecentFailedAttempts(ip string, serviceID int, duration time.Duration) (int, error) {
 	var count int
 	cutoff := time.Now().Add(-duration)
 	err := DB.QueryRow(
-		"SELECT COUNT(*) FROM login_attempts WHERE ip = ? AND success = 0 AND timestamp > ?",
-		ip, cutoff,
+		`SELECT COUNT(*) FROM login_attempts
+			WHERE ip = ? AND service_id = ? AND success = 0 AND method != 'knock_incomplete' AND timestamp > ?`,
+		ip, serviceID, cutoff,
 	).Scan(&count)
 	return count, err
 }
@@ -194,19 +783,19 @@ func CleanupOldLoginAttempts() error {
 	return err
 }
 
-func GetActiveWhitelistIPs() ([]string, error) {
+func GetActiveWhitelistIPs() ([]models.WhitelistIP, error) {
 	rows, err := DB.Query(
-		"SELECT ip FROM whitelist_ips WHERE is_permanent = 1 OR expires_at > datetime('now')",
+		"SELECT id, ip, ports FROM whitelist_ips WHERE is_permanent = 1 OR expires_at > datetime('now')",
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ips []string
+	var ips []models.WhitelistIP
 	for rows.Next() {
-		var ip string
-		if err := rows.Scan(&ip); err != nil {
+		var ip models.WhitelistIP
+		if err := rows.Scan(&ip.ID, &ip.IP, &ip.Ports); err != nil {
 			return nil, err
 		}
 		ips = append(ips, ip)
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const denySelect = `SELECT id, address, reason, created_at, expires_at FROM deny_list`
+
+func scanDenyEntry(row rowScanner) (models.DenyEntry, error) {
+	var d models.DenyEntry
+	var expiresAt sql.NullTime
+	err := row.Scan(&d.ID, &d.Address, &d.Reason, &d.CreatedAt, &expiresAt)
+	if err != nil {
+		return d, err
+	}
+	if expiresAt.Valid {
+		d.ExpiresAt = &expiresAt.Time
+	}
+	return d, nil
+}
+
+func queryDenyEntries(query string, args ...interface{}) ([]models.DenyEntry, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.DenyEntry
+	for rows.Next() {
+		d, err := scanDenyEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, d)
+	}
+	return entries, rows.Err()
+}
+
+func GetAllDenyEntries() ([]models.DenyEntry, error) {
+	return queryDenyEntries(denySelect + " ORDER BY id")
+}
+
+// GetActiveDenyEntries 返回未过期的黑名单条目
+func GetActiveDenyEntries() ([]models.DenyEntry, error) {
+	return queryDenyEntries(denySelect+" WHERE expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+func GetDenyEntry(id int) (*models.DenyEntry, error) {
+	d, err := scanDenyEntry(DB.QueryRow(denySelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func AddDenyEntry(d models.DenyEntry) (int, error) {
+	var expiresAt interface{}
+	if d.ExpiresAt != nil {
+		expiresAt = *d.ExpiresAt
+	}
+
+	var id int
+	err := DB.QueryRow(
+		"INSERT INTO deny_list (address, reason, expires_at) VALUES (?, ?, ?) RETURNING id",
+		d.Address, d.Reason, expiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+func DeleteDenyEntry(id int) error {
+	_, err := DB.Exec("DELETE FROM deny_list WHERE id = ?", id)
+	return err
+}
+
+func CleanupExpiredDenyEntries() error {
+	_, err := DB.Exec("DELETE FROM deny_list WHERE expires_at IS NOT NULL AND expires_at < ?", time.Now())
+	return err
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const egressSelect = `SELECT id, destination, ports, description, created_at, expires_at FROM egress_rules`
+
+func scanEgressRule(row rowScanner) (models.EgressRule, error) {
+	var r models.EgressRule
+	var expiresAt sql.NullTime
+	err := row.Scan(&r.ID, &r.Destination, &r.Ports, &r.Description, &r.CreatedAt, &expiresAt)
+	if err != nil {
+		return r, err
+	}
+	if expiresAt.Valid {
+		r.ExpiresAt = &expiresAt.Time
+	}
+	return r, nil
+}
+
+func queryEgressRules(query string, args ...interface{}) ([]models.EgressRule, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.EgressRule
+	for rows.Next() {
+		r, err := scanEgressRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func GetAllEgressRules() ([]models.EgressRule, error) {
+	return queryEgressRules(egressSelect + " ORDER BY id")
+}
+
+// GetActiveEgressRules 返回未过期的出站条目
+func GetActiveEgressRules() ([]models.EgressRule, error) {
+	return queryEgressRules(egressSelect+" WHERE expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+func GetEgressRule(id int) (*models.EgressRule, error) {
+	r, err := scanEgressRule(DB.QueryRow(egressSelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func AddEgressRule(r models.EgressRule) (int, error) {
+	var expiresAt interface{}
+	if r.ExpiresAt != nil {
+		expiresAt = *r.ExpiresAt
+	}
+
+	var id int
+	err := DB.QueryRow(
+		"INSERT INTO egress_rules (destination, ports, description, expires_at) VALUES (?, ?, ?, ?) RETURNING id",
+		r.Destination, r.Ports, r.Description, expiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+func DeleteEgressRule(id int) error {
+	if _, err := DB.Exec("DELETE FROM egress_addresses WHERE egress_id = ?", id); err != nil {
+		return err
+	}
+	_, err := DB.Exec("DELETE FROM egress_rules WHERE id = ?", id)
+	return err
+}
+
+func CleanupExpiredEgressRules() error {
+	_, err := DB.Exec("DELETE FROM egress_rules WHERE expires_at IS NOT NULL AND expires_at < ?", time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("DELETE FROM egress_addresses WHERE egress_id NOT IN (SELECT id FROM egress_rules)")
+	return err
+}
+
+// GetEgressAddresses 返回域名出站条目当前解析到的地址
+func GetEgressAddresses(egressID int) ([]models.EgressAddress, error) {
+	rows, err := DB.Query(
+		"SELECT address, ttl_seconds, resolved_at, expires_at FROM egress_addresses WHERE egress_id = ? ORDER BY address",
+		egressID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addrs []models.EgressAddress
+	for rows.Next() {
+		var a models.EgressAddress
+		if err := rows.Scan(&a.Address, &a.TTLSeconds, &a.ResolvedAt, &a.ExpiresAt); err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, rows.Err()
+}
+
+// SaveEgressAddress 记录 now 时的一次解析结果，已存在的地址会刷新TTL和过期时间
+func SaveEgressAddress(egressID int, address string, ttl time.Duration, now time.Time) error {
+	_, err := DB.Exec(
+		`INSERT INTO egress_addresses (egress_id, address, ttl_seconds, resolved_at, expires_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(egress_id, address) DO UPDATE SET ttl_seconds = excluded.ttl_seconds,
+			resolved_at = excluded.resolved_at, expires_at = excluded.expires_at`,
+		egressID, address, int(ttl/time.Second), now, now.Add(ttl),
+	)
+	return err
+}
+
+// DeleteStaleEgressAddresses 删除在 before 之前就已过期的地址
+func DeleteStaleEgressAddresses(egressID int, before time.Time) error {
+	_, err := DB.Exec("DELETE FROM egress_addresses WHERE egress_id = ? AND expires_at < ?", egressID, before)
+	return err
+}
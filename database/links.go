@@ -0,0 +1,136 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const accessLinkSelect = `SELECT id, description, service_ids, max_uses, uses, duration_minutes, expires_at,
+	created_at, revoked_at FROM access_links`
+
+func scanAccessLink(row rowScanner) (models.AccessLink, error) {
+	var l models.AccessLink
+	var serviceIDs string
+	var revokedAt sql.NullTime
+	err := row.Scan(&l.ID, &l.Description, &serviceIDs, &l.MaxUses, &l.Uses, &l.DurationMinutes, &l.ExpiresAt,
+		&l.CreatedAt, &revokedAt)
+	if err != nil {
+		return l, err
+	}
+	l.ServiceIDs = parseServiceIDs(serviceIDs)
+	if revokedAt.Valid {
+		l.RevokedAt = &revokedAt.Time
+	}
+	return l, nil
+}
+
+// GetAllAccessLinks 返回所有访问链接，最新的在前
+func GetAllAccessLinks() ([]models.AccessLink, error) {
+	rows, err := DB.Query(accessLinkSelect + " ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.AccessLink
+	for rows.Next() {
+		l, err := scanAccessLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+func GetAccessLink(id int) (*models.AccessLink, error) {
+	l, err := scanAccessLink(DB.QueryRow(accessLinkSelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func AddAccessLink(l models.AccessLink) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO access_links (description, service_ids, max_uses, duration_minutes, expires_at)
+			VALUES (?, ?, ?, ?, ?) RETURNING id`,
+		l.Description, formatServiceIDs(l.ServiceIDs), l.MaxUses, l.DurationMinutes, l.ExpiresAt,
+	).Scan(&id)
+	return id, err
+}
+
+// RevokeAccessLink 使链接立即失效，已解锁的白名单条目保留到过期
+func RevokeAccessLink(id int) error {
+	_, err := DB.Exec("UPDATE access_links SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	return err
+}
+
+// ConsumeAccessLinkUse 在链接未撤销、未过期且未用完时占用一次使用次数，返回是否占用成功。
+// 检查和计数在同一条语句中完成，并发打开同一链接也不会超出次数
+func ConsumeAccessLinkUse(id int, now time.Time) (bool, error) {
+	result, err := DB.Exec(
+		`UPDATE access_links SET uses = uses + 1
+			WHERE id = ? AND revoked_at IS NULL AND expires_at > ? AND uses < max_uses`,
+		id, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseAccessLinkUse 解锁失败时归还占用的使用次数
+func ReleaseAccessLinkUse(id int) error {
+	_, err := DB.Exec("UPDATE access_links SET uses = uses - 1 WHERE id = ? AND uses > 0", id)
+	return err
+}
+
+// AddAccessLinkUse 记录一次链接使用
+func AddAccessLinkUse(u models.AccessLinkUse) error {
+	_, err := DB.Exec(
+		"INSERT INTO access_link_uses (link_id, ip, service_id, country, success, detail) VALUES (?, ?, ?, ?, ?, ?)",
+		u.LinkID, u.IP, u.ServiceID, u.Country, u.Success, u.Detail,
+	)
+	return err
+}
+
+// GetAccessLinkUses 返回链接的使用记录，最新的在前
+func GetAccessLinkUses(linkID int) ([]models.AccessLinkUse, error) {
+	rows, err := DB.Query(
+		`SELECT u.id, u.link_id, u.ip, u.service_id, COALESCE(s.name, ''), u.country, u.success, u.detail,
+			u.created_at FROM access_link_uses u LEFT JOIN services s ON s.id = u.service_id
+			WHERE u.link_id = ? ORDER BY u.id DESC`,
+		linkID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uses []models.AccessLinkUse
+	for rows.Next() {
+		var u models.AccessLinkUse
+		if err := rows.Scan(&u.ID, &u.LinkID, &u.IP, &u.ServiceID, &u.ServiceName, &u.Country, &u.Success,
+			&u.Detail, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		uses = append(uses, u)
+	}
+	return uses, rows.Err()
+}
+
+// InitLinkKey 在尚未设置时保存访问链接的签名密钥，返回最终生效的密钥。
+// 并发调用时只有第一次写入生效
+func InitLinkKey(key string) (string, error) {
+	if _, err := DB.Exec("UPDATE config SET link_key = ? WHERE id = 1 AND link_key = ''", key); err != nil {
+		return "", err
+	}
+	var current string
+	err := DB.QueryRow("SELECT link_key FROM config WHERE id = 1").Scan(&current)
+	return current, err
+}
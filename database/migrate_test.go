@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// baselineSchema 为最早版本创建的表结构
+var baselineSchema = []string{
+	`CREATE TABLE whitelist_ips (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ip TEXT NOT NULL UNIQUE,
+		description TEXT,
+		is_permanent BOOLEAN DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME
+	)`,
+	`CREATE TABLE config (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_password TEXT NOT NULL,
+		admin_password TEXT NOT NULL
+	)`,
+	`CREATE TABLE login_attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ip TEXT NOT NULL,
+		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+		success BOOLEAN DEFAULT 0
+	)`,
+	`CREATE INDEX idx_login_attempts_ip ON login_attempts(ip, timestamp)`,
+}
+
+func TestMigrateFromBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.db")
+	old, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for _, query := range baselineSchema {
+		if _, err := old.Exec(query); err != nil {
+			t.Fatalf("failed to create baseline schema: %v", err)
+		}
+	}
+	_, err = old.Exec("INSERT INTO whitelist_ips (ip, description, is_permanent, expires_at) VALUES (?, ?, ?, ?)",
+		"192.0.2.10", "office", true, time.Now())
+	if err != nil {
+		t.Fatalf("failed to insert baseline entry: %v", err)
+	}
+	old.Close()
+
+	if err := InitDB(path); err != nil {
+		t.Fatalf("failed to migrate baseline database: %v", err)
+	}
+	defer DB.Close()
+
+	for _, col := range migrationColumns {
+		exists, err := hasColumn(col.table, col.column)
+		if err != nil {
+			t.Fatalf("failed to inspect %s: %v", col.table, err)
+		}
+		if !exists {
+			t.Errorf("column %s.%s is missing after migration", col.table, col.column)
+		}
+	}
+
+	entries, err := GetAllWhitelistIPs()
+	if err != nil {
+		t.Fatalf("failed to read migrated whitelist: %v", err)
+	}
+	if len(entries) != 1 || entries[0].IP != "192.0.2.10" || !entries[0].IsPermanent {
+		t.Fatalf("got %+v, want the baseline entry", entries)
+	}
+	if _, err := GetActiveWhitelistIPs(); err != nil {
+		t.Fatalf("failed to read active whitelist: %v", err)
+	}
+}
+
+func TestFreshSchemaHasMigratedColumns(t *testing.T) {
+	if err := InitDB(filepath.Join(t.TempDir(), "fresh.db")); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	defer DB.Close()
+
+	for _, col := range migrationColumns {
+		exists, err := hasColumn(col.table, col.column)
+		if err != nil {
+			t.Fatalf("failed to inspect %s: %v", col.table, err)
+		}
+		if !exists {
+			t.Errorf("column %s.%s is missing from the CREATE TABLE statement", col.table, col.column)
+		}
+	}
+}
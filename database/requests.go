@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const accessRequestSelect = `SELECT r.id, r.name, r.reason, r.ip, r.country, r.service_id, COALESCE(s.name, ''),
+	r.duration_minutes, r.status, r.note, r.token, r.created_at, r.decided_at, r.expires_at
+	FROM access_requests r LEFT JOIN services s ON s.id = r.service_id`
+
+func scanAccessRequest(row rowScanner) (models.AccessRequest, error) {
+	var r models.AccessRequest
+	var decidedAt, expiresAt sql.NullTime
+	err := row.Scan(&r.ID, &r.Name, &r.Reason, &r.IP, &r.Country, &r.ServiceID, &r.ServiceName,
+		&r.DurationMinutes, &r.Status, &r.Note, &r.Token, &r.CreatedAt, &decidedAt, &expiresAt)
+	if err != nil {
+		return r, err
+	}
+	if decidedAt.Valid {
+		r.DecidedAt = &decidedAt.Time
+	}
+	if expiresAt.Valid {
+		r.ExpiresAt = &expiresAt.Time
+	}
+	return r, nil
+}
+
+// GetAccessRequests 返回待处理的申请和 since 之后提交的其他申请，最新的在前
+func GetAccessRequests(since time.Time) ([]models.AccessRequest, error) {
+	rows, err := DB.Query(accessRequestSelect+" WHERE r.status = 'pending' OR r.created_at > ? ORDER BY r.id DESC", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.AccessRequest
+	for rows.Next() {
+		r, err := scanAccessRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, r)
+	}
+	return requests, rows.Err()
+}
+
+func GetAccessRequest(id int) (*models.AccessRequest, error) {
+	r, err := scanAccessRequest(DB.QueryRow(accessRequestSelect+" WHERE r.id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetAccessRequestByToken 按申请人持有的随机值查找申请
+func GetAccessRequestByToken(token string) (*models.AccessRequest, error) {
+	r, err := scanAccessRequest(DB.QueryRow(accessRequestSelect+" WHERE r.token = ?", token))
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func AddAccessRequest(r models.AccessRequest) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		`INSERT INTO access_requests (name, reason, ip, country, service_id, duration_minutes, token, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		r.Name, r.Reason, r.IP, r.Country, r.ServiceID, r.DurationMinutes, r.Token, time.Now(),
+	).Scan(&id)
+	return id, err
+}
+
+// CountPendingAccessRequests 返回该IP尚未处理的申请数量
+func CountPendingAccessRequests(ip string) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM access_requests WHERE ip = ? AND status = 'pending'", ip).Scan(&count)
+	return count, err
+}
+
+// CountAllPendingAccessRequests 返回所有IP尚未处理的申请数量
+func CountAllPendingAccessRequests() (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM access_requests WHERE status = 'pending'").Scan(&count)
+	return count, err
+}
+
+// DecideAccessRequest 把待处理的申请改为 status，返回是否修改成功。
+// 只有仍处于待处理状态的申请会被修改，两个管理员同时处理时只有一个生效
+func DecideAccessRequest(id int, status, note string, durationMinutes int) (bool, error) {
+	result, err := DB.Exec(
+		`UPDATE access_requests SET status = ?, note = ?, duration_minutes = ?, decided_at = ?
+			WHERE id = ? AND status = 'pending'`,
+		status, note, durationMinutes, time.Now(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ReopenAccessRequest 在批准后解锁失败时把申请恢复为待处理，并恢复申请的时长
+func ReopenAccessRequest(id, durationMinutes int) error {
+	_, err := DB.Exec(
+		"UPDATE access_requests SET status = 'pending', note = '', duration_minutes = ?, decided_at = NULL WHERE id = ?",
+		durationMinutes, id,
+	)
+	return err
+}
+
+// SetAccessRequestExpiry 记录批准后白名单的过期时间
+func SetAccessRequestExpiry(id int, expiresAt time.Time) error {
+	_, err := DB.Exec("UPDATE access_requests SET expires_at = ? WHERE id = ?", expiresAt, id)
+	return err
+}
+
+// ExpireAccessRequests 将 before 之前提交且仍未处理的申请标记为过期，返回被标记的申请
+func ExpireAccessRequests(before time.Time) ([]models.AccessRequest, error) {
+	rows, err := DB.Query(accessRequestSelect+" WHERE r.status = 'pending' AND r.created_at < ?", before)
+	if err != nil {
+		return nil, err
+	}
+	var expired []models.AccessRequest
+	for rows.Next() {
+		r, err := scanAccessRequest(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []models.AccessRequest
+	for _, r := range expired {
+		ok, err := DecideAccessRequest(r.ID, "expired", "", r.DurationMinutes)
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			r.Status = "expired"
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
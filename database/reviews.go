@@ -0,0 +1,47 @@
+package database
+
+import (
+	"time"
+
+	"iptables-safe/models"
+)
+
+// MarkWhitelistReviewed 记录条目通过复核的时间
+func MarkWhitelistReviewed(id int, at time.Time) error {
+	_, err := DB.Exec("UPDATE whitelist_ips SET last_reviewed_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+// AddReviewDecision 记录一次复核决定，条目被删除后记录仍然保留
+func AddReviewDecision(d models.ReviewDecision) error {
+	_, err := DB.Exec(
+		`INSERT INTO review_decisions (entry_id, ip, description, decision, reasons, note, admin_ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		d.EntryID, d.IP, d.Description, d.Decision, d.Reasons, d.Note, d.AdminIP,
+	)
+	return err
+}
+
+// GetReviewDecisions 返回最近的复核决定
+func GetReviewDecisions(limit int) ([]models.ReviewDecision, error) {
+	rows, err := DB.Query(
+		`SELECT id, entry_id, ip, description, decision, reasons, note, admin_ip, created_at
+		FROM review_decisions ORDER BY id DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []models.ReviewDecision
+	for rows.Next() {
+		var d models.ReviewDecision
+		if err := rows.Scan(&d.ID, &d.EntryID, &d.IP, &d.Description, &d.Decision, &d.Reasons,
+			&d.Note, &d.AdminIP, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"iptables-safe/models"
+)
+
+const serviceSelect = `SELECT id, name, ports, password, max_duration_minutes, max_failed_attempts,
+	lockout_minutes, created_at FROM services`
+
+func scanService(row rowScanner) (models.Service, error) {
+	var s models.Service
+	err := row.Scan(&s.ID, &s.Name, &s.Ports, &s.Password, &s.MaxDurationMinutes,
+		&s.MaxFailedAttempts, &s.LockoutMinutes, &s.CreatedAt)
+	return s, err
+}
+
+// parseServiceIDs 解析以逗号分隔的服务ID列表，0 表示默认登录
+func parseServiceIDs(s string) []int {
+	ids := []int{}
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.Atoi(part); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func formatServiceIDs(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func GetAllServices() ([]models.Service, error) {
+	rows, err := DB.Query(serviceSelect + " ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var services []models.Service
+	for rows.Next() {
+		s, err := scanService(rows)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+func GetService(id int) (*models.Service, error) {
+	s, err := scanService(DB.QueryRow(serviceSelect+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetServiceByName 按名称查找服务
+func GetServiceByName(name string) (*models.Service, error) {
+	s, err := scanService(DB.QueryRow(serviceSelect+" WHERE name = ?", name))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetServiceByNameFold 按名称查找服务，不区分大小写，用于DNS这类会改变大小写的渠道
+func GetServiceByNameFold(name string) (*models.Service, error) {
+	s, err := scanService(DB.QueryRow(serviceSelect+" WHERE name = ? COLLATE NOCASE", name))
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// AddService 新增服务，password 为明文，保存时使用bcrypt加密
+func AddService(s models.Service, password string) (int, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = DB.QueryRow(
+		`INSERT INTO services (name, ports, password, max_duration_minutes, max_failed_attempts, lockout_minutes)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		s.Name, s.Ports, string(hash), s.MaxDurationMinutes, s.MaxFailedAttempts, s.LockoutMinutes,
+	).Scan(&id)
+	return id, err
+}
+
+// UpdateService 修改服务配置，password 为空时保留原密码
+func UpdateService(s models.Service, password string) error {
+	_, err := DB.Exec(
+		`UPDATE services SET name = ?, ports = ?, max_duration_minutes = ?, max_failed_attempts = ?,
+		lockout_minutes = ? WHERE id = ?`,
+		s.Name, s.Ports, s.MaxDurationMinutes, s.MaxFailedAttempts, s.LockoutMinutes, s.ID,
+	)
+	if err != nil || password == "" {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("UPDATE services SET password = ? WHERE id = ?", string(hash), s.ID)
+	return err
+}
+
+// DeleteService 删除服务及其全部白名单条目，返回被删除的条目ID以便撤销防火墙规则
+func DeleteService(id int) ([]int, error) {
+	rows, err := DB.Query("SELECT id FROM whitelist_ips WHERE service_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var entryIDs []int
+	for rows.Next() {
+		var entryID int
+		if err := rows.Scan(&entryID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entryIDs = append(entryIDs, entryID)
+	}
+	rows.Close()
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM whitelist_ips WHERE service_id = ?", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM services WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return entryIDs, tx.Commit()
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"time"
+
+	"iptables-safe/models"
+)
+
+// AddTrafficSample 记录白名单条目一个采样周期的流量增量，有入站流量时同时更新条目的最近活跃时间
+func AddTrafficSample(entryID int, sample models.TrafficSample) error {
+	_, err := DB.Exec(
+		`INSERT INTO whitelist_traffic (entry_id, sampled_at, packets_in, bytes_in, packets_out, bytes_out)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		entryID, sample.SampledAt, sample.PacketsIn, sample.BytesIn, sample.PacketsOut, sample.BytesOut,
+	)
+	if err != nil || sample.PacketsIn == 0 {
+		return err
+	}
+	_, err = DB.Exec("UPDATE whitelist_ips SET last_active_at = ? WHERE id = ?", sample.SampledAt, entryID)
+	return err
+}
+
+// GetTrafficSamples 返回条目自 since 之后的流量采样，按时间升序
+func GetTrafficSamples(entryID int, since time.Time) ([]models.TrafficSample, error) {
+	rows, err := DB.Query(
+		`SELECT sampled_at, packets_in, bytes_in, packets_out, bytes_out FROM whitelist_traffic
+		WHERE entry_id = ? AND sampled_at >= ? ORDER BY sampled_at`,
+		entryID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []models.TrafficSample
+	for rows.Next() {
+		var s models.TrafficSample
+		if err := rows.Scan(&s.SampledAt, &s.PacketsIn, &s.BytesIn, &s.PacketsOut, &s.BytesOut); err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+// GetTrafficTotals 返回每个条目在保留期内的流量合计
+func GetTrafficTotals() (map[int]models.TrafficStats, error) {
+	rows, err := DB.Query(
+		`SELECT entry_id, SUM(packets_in), SUM(bytes_in), SUM(packets_out), SUM(bytes_out)
+		FROM whitelist_traffic GROUP BY entry_id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[int]models.TrafficStats)
+	for rows.Next() {
+		var id int
+		var t models.TrafficStats
+		if err := rows.Scan(&id, &t.PacketsIn, &t.BytesIn, &t.PacketsOut, &t.BytesOut); err != nil {
+			return nil, err
+		}
+		totals[id] = t
+	}
+	return totals, rows.Err()
+}
+
+// CleanupOldTrafficSamples 删除超过保留期的流量采样
+func CleanupOldTrafficSamples(retention time.Duration) error {
+	_, err := DB.Exec("DELETE FROM whitelist_traffic WHERE sampled_at < ?", time.Now().Add(-retention))
+	return err
+}
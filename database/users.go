@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"iptables-safe/models"
+)
+
+const sshKeySelect = `SELECT k.id, k.user_id, u.name, k.public_key, k.fingerprint, k.comment, k.created_at,
+	k.last_used_at FROM ssh_keys k JOIN users u ON u.id = k.user_id`
+
+func scanSSHKey(row rowScanner) (models.SSHKey, error) {
+	var k models.SSHKey
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.UserID, &k.UserName, &k.PublicKey, &k.Fingerprint, &k.Comment, &k.CreatedAt,
+		&lastUsedAt)
+	if err != nil {
+		return k, err
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return k, nil
+}
+
+// GetAllUsers 返回所有用户及其SSH公钥
+func GetAllUsers() ([]models.User, error) {
+	rows, err := DB.Query("SELECT id, name, dns_secret, service_ids, created_at FROM users ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	index := make(map[int]int)
+	for rows.Next() {
+		var u models.User
+		var serviceIDs string
+		if err := rows.Scan(&u.ID, &u.Name, &u.DNSSecret, &serviceIDs, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		u.DNSKnock = u.DNSSecret != ""
+		u.ServiceIDs = parseServiceIDs(serviceIDs)
+		u.Keys = []models.SSHKey{}
+		index[u.ID] = len(users)
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keyRows, err := DB.Query(sshKeySelect + " ORDER BY k.id")
+	if err != nil {
+		return nil, err
+	}
+	defer keyRows.Close()
+
+	for keyRows.Next() {
+		k, err := scanSSHKey(keyRows)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := index[k.UserID]; ok {
+			users[i].Keys = append(users[i].Keys, k)
+		}
+	}
+	return users, keyRows.Err()
+}
+
+func GetUser(id int) (*models.User, error) {
+	var u models.User
+	var serviceIDs string
+	err := DB.QueryRow("SELECT id, name, dns_secret, service_ids, created_at FROM users WHERE id = ?", id).Scan(
+		&u.ID, &u.Name, &u.DNSSecret, &serviceIDs, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	u.DNSKnock = u.DNSSecret != ""
+	u.ServiceIDs = parseServiceIDs(serviceIDs)
+	return &u, nil
+}
+
+// GetDNSKnockUsers 返回设置了DNS解锁密钥的用户，不含SSH公钥
+func GetDNSKnockUsers() ([]models.User, error) {
+	rows, err := DB.Query("SELECT id, name, dns_secret, service_ids, created_at FROM users WHERE dns_secret != '' ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		var serviceIDs string
+		if err := rows.Scan(&u.ID, &u.Name, &u.DNSSecret, &serviceIDs, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		u.DNSKnock = true
+		u.ServiceIDs = parseServiceIDs(serviceIDs)
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UpdateUserDNSSecret 设置用户的DNS解锁密钥，为空表示停用
+func UpdateUserDNSSecret(id int, secret string) error {
+	_, err := DB.Exec("UPDATE users SET dns_secret = ? WHERE id = ?", secret, id)
+	return err
+}
+
+// UpdateUserServices 设置用户可以通过SSH或DNS解锁的服务
+func UpdateUserServices(id int, serviceIDs []int) error {
+	_, err := DB.Exec("UPDATE users SET service_ids = ? WHERE id = ?", formatServiceIDs(serviceIDs), id)
+	return err
+}
+
+func AddUser(name string) (int, error) {
+	var id int
+	err := DB.QueryRow("INSERT INTO users (name) VALUES (?) RETURNING id", name).Scan(&id)
+	return id, err
+}
+
+// DeleteUser 删除用户及其所有SSH公钥
+func DeleteUser(id int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM ssh_keys WHERE user_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func AddSSHKey(k models.SSHKey) (int, error) {
+	var id int
+	err := DB.QueryRow(
+		"INSERT INTO ssh_keys (user_id, public_key, fingerprint, comment) VALUES (?, ?, ?, ?) RETURNING id",
+		k.UserID, k.PublicKey, k.Fingerprint, k.Comment,
+	).Scan(&id)
+	return id, err
+}
+
+func GetSSHKey(id int) (*models.SSHKey, error) {
+	k, err := scanSSHKey(DB.QueryRow(sshKeySelect+" WHERE k.id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// GetSSHKeyByFingerprint 按SHA256指纹查找公钥，用于SSH认证
+func GetSSHKeyByFingerprint(fingerprint string) (*models.SSHKey, error) {
+	k, err := scanSSHKey(DB.QueryRow(sshKeySelect+" WHERE k.fingerprint = ?", fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func DeleteSSHKey(id int) error {
+	_, err := DB.Exec("DELETE FROM ssh_keys WHERE id = ?", id)
+	return err
+}
+
+// TouchSSHKey 记录公钥最近一次成功解锁的时间
+func TouchSSHKey(id int, at time.Time) error {
+	_, err := DB.Exec("UPDATE ssh_keys SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
@@ -0,0 +1,372 @@
+package dnsknock
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/models"
+)
+
+const (
+	// ednsPayloadSize 为响应中声明的UDP负载上限
+	ednsPayloadSize = 1232
+	// optionClientSubnet 为EDNS Client Subnet选项的代码（RFC 7871）
+	optionClientSubnet = 8
+	// maxQuerySize 为接受的最大查询长度
+	maxQuerySize = 512
+)
+
+var (
+	mu         sync.Mutex
+	listenPort int
+	zone       string
+	// usedTokens 记录有效期内已使用的令牌及其结果。解析器重试或多个解析器重复查询时
+	// 返回同样的结果，但不会再次放行
+	usedTokens = make(map[string]usedToken)
+)
+
+type usedToken struct {
+	status  string
+	expires time.Time
+}
+
+// Port 返回DNS解锁服务监听的端口，未启用时返回0
+func Port() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return listenPort
+}
+
+// Zone 返回DNS解锁服务负责的域名
+func Zone() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return zone
+}
+
+// ListenAndServe 在UDP端口上作为 knockZone 的权威DNS服务器，只在监听失败时返回
+func ListenAndServe(port int, knockZone string) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mu.Lock()
+	listenPort = port
+	zone = strings.ToLower(strings.TrimSuffix(knockZone, "."))
+	mu.Unlock()
+	log.Printf("DNS knock server started on udp/%d for %s", port, Zone())
+
+	buf := make([]byte, maxQuerySize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		response := handleQuery(buf[:n], addr.IP.String())
+		if response != nil {
+			conn.WriteToUDP(response, addr)
+		}
+	}
+}
+
+// query 是从请求中解析出的内容
+type query struct {
+	header   dnsmessage.Header
+	question dnsmessage.Question
+	// edns 表示请求带有OPT记录，响应也需要带上
+	edns bool
+	// subnet 为请求中的Client Subnet选项，响应中原样带回并把作用范围设为源前缀长度
+	subnet *dnsmessage.Option
+	// clientIP 为Client Subnet中完整的IPv4地址（/32），未提供时为空
+	clientIP string
+}
+
+// handleQuery 处理一个DNS请求并返回响应，无法解析的请求不回复
+func handleQuery(packet []byte, resolverIP string) []byte {
+	q, err := parseQuery(packet)
+	if err != nil {
+		return nil
+	}
+
+	if q.header.OpCode != 0 {
+		return buildResponse(q, dnsmessage.RCodeNotImplemented, "")
+	}
+	if q.question.Class != dnsmessage.ClassINET {
+		return buildResponse(q, dnsmessage.RCodeRefused, "")
+	}
+
+	knockZone := Zone()
+	name := strings.ToLower(strings.TrimSuffix(q.question.Name.String(), "."))
+	if name == knockZone {
+		return buildResponse(q, dnsmessage.RCodeSuccess, "")
+	}
+	if !strings.HasSuffix(name, "."+knockZone) {
+		return buildResponse(q, dnsmessage.RCodeRefused, "")
+	}
+
+	// <令牌>.zone 为默认登录，<令牌>.<服务>.zone 解锁指定服务
+	labels := strings.Split(strings.TrimSuffix(name, "."+knockZone), ".")
+	if len(labels) > 2 {
+		return buildResponse(q, dnsmessage.RCodeNameError, "")
+	}
+	token, service := labels[0], ""
+	if len(labels) == 2 {
+		service = labels[1]
+	}
+
+	// 优先使用解析器转发的客户端地址，否则放行解析器自身的地址
+	ip := resolverIP
+	if q.clientIP != "" {
+		ip = q.clientIP
+	}
+	return buildResponse(q, dnsmessage.RCodeSuccess, knock(ip, token, service, time.Now()))
+}
+
+func parseQuery(packet []byte) (*query, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(packet)
+	if err != nil {
+		return nil, err
+	}
+	if header.Response {
+		return nil, errors.New("not a query")
+	}
+	question, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return nil, err
+	}
+
+	q := &query{header: header, question: question}
+	for {
+		h, err := p.AdditionalHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Type != dnsmessage.TypeOPT {
+			if err := p.SkipAdditional(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		opt, err := p.OPTResource()
+		if err != nil {
+			return nil, err
+		}
+		q.edns = true
+		for _, o := range opt.Options {
+			if o.Code == optionClientSubnet {
+				q.subnet, q.clientIP = parseClientSubnet(o)
+			}
+		}
+	}
+	return q, nil
+}
+
+// parseClientSubnet 解析Client Subnet选项：地址族(2) | 源前缀长度(1) | 作用前缀长度(1) | 地址。
+// 只有完整的IPv4地址才能放行，公共解析器通常只转发/24，此时仍使用解析器地址
+func parseClientSubnet(o dnsmessage.Option) (*dnsmessage.Option, string) {
+	if len(o.Data) < 4 {
+		return nil, ""
+	}
+	family, sourcePrefix := binary.BigEndian.Uint16(o.Data[:2]), o.Data[2]
+
+	echo := dnsmessage.Option{Code: o.Code, Data: append([]byte(nil), o.Data...)}
+	echo.Data[3] = sourcePrefix
+
+	address := o.Data[4:]
+	if family == 1 && sourcePrefix == 32 && len(address) == 4 {
+		ip := net.IP(address)
+		if !ip.IsUnspecified() {
+			return &echo, ip.String()
+		}
+	}
+	return &echo, ""
+}
+
+func buildResponse(q *query, rcode dnsmessage.RCode, status string) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               q.header.ID,
+		Response:         true,
+		OpCode:           q.header.OpCode,
+		Authoritative:    rcode != dnsmessage.RCodeRefused,
+		RecursionDesired: q.header.RecursionDesired,
+		RCode:            rcode,
+	})
+	b.EnableCompression()
+
+	if err := b.StartQuestions(); err != nil {
+		return nil
+	}
+	if err := b.Question(q.question); err != nil {
+		return nil
+	}
+
+	// 其他类型的查询同样会放行，只是没有TXT结果可以返回
+	if status != "" && (q.question.Type == dnsmessage.TypeTXT || q.question.Type == dnsmessage.TypeALL) {
+		if err := b.StartAnswers(); err != nil {
+			return nil
+		}
+		// TTL为0，避免解析器缓存结果导致后续查询不再到达本服务
+		h := dnsmessage.ResourceHeader{Name: q.question.Name, Class: dnsmessage.ClassINET, TTL: 0}
+		if err := b.TXTResource(h, dnsmessage.TXTResource{TXT: []string{status}}); err != nil {
+			return nil
+		}
+	}
+
+	if q.edns {
+		if err := b.StartAdditionals(); err != nil {
+			return nil
+		}
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(ednsPayloadSize, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil
+		}
+		var opt dnsmessage.OPTResource
+		if q.subnet != nil {
+			opt.Options = append(opt.Options, *q.subnet)
+		}
+		if err := b.OPTResource(h, opt); err != nil {
+			return nil
+		}
+	}
+
+	response, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return response
+}
+
+// knock 验证令牌并放行IP，返回写入TXT记录的结果。
+// 查询的来源是解析器而不是用户本人，且UDP来源可以伪造，令牌无效不计入登录失败
+func knock(ip, token, service string, now time.Time) string {
+	key := token + "." + service
+
+	mu.Lock()
+	for t, used := range usedTokens {
+		if now.After(used.expires) {
+			delete(usedTokens, t)
+		}
+	}
+	if used, ok := usedTokens[key]; ok {
+		mu.Unlock()
+		return used.status
+	}
+	mu.Unlock()
+
+	user, err := findUser(service, token, now)
+	if err != nil {
+		log.Printf("Error looking up DNS knock users: %v", err)
+		return "error: internal server error"
+	}
+	if user == nil {
+		return "denied: invalid token"
+	}
+
+	status := grant(ip, user, service)
+	mu.Lock()
+	// 令牌在生成后最多 2*TokenStep 内有效，记录保留到令牌失效
+	usedTokens[key] = usedToken{status: status, expires: now.Add(3 * TokenStep)}
+	mu.Unlock()
+	return status
+}
+
+// findUser 返回令牌所属的用户，没有匹配的用户时返回nil
+func findUser(service, token string, now time.Time) (*models.User, error) {
+	users, err := database.GetDNSKnockUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		secret, err := ParseSecret(u.DNSSecret)
+		if err != nil {
+			log.Printf("Invalid DNS knock secret of user %s: %v", u.Name, err)
+			continue
+		}
+		if Verify(secret, service, token, now) {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func grant(ip string, u *models.User, service string) string {
+	user := u.Name
+	policy, err := resolvePolicy(u, service)
+	if err == sql.ErrNoRows {
+		log.Printf("Rejected DNS knock from %s (%s): unknown service %q", ip, user, service)
+		return "denied: unknown service"
+	}
+	if errors.Is(err, access.ErrServiceNotAllowed) {
+		log.Printf("Rejected DNS knock from %s (%s): service %q not allowed", ip, user, service)
+		return "denied: service not allowed"
+	}
+	if err != nil {
+		log.Printf("Error getting login policy: %v", err)
+		return "error: internal server error"
+	}
+	if policy.IsLockedOut(ip) {
+		log.Printf("Ignored DNS knock from locked out IP %s", ip)
+		return "denied: too many failed attempts"
+	}
+
+	description := "DNS knock: " + user
+	if policy.ServiceName != "" {
+		description = fmt.Sprintf("DNS knock: %s (%s)", user, policy.ServiceName)
+	}
+	expiresAt, err := access.GrantUser(ip, u.ID, access.MethodDNS, policy, description)
+	if errors.Is(err, access.ErrCountryDenied) {
+		log.Printf("Rejected DNS knock from %s (%s): country not allowed", ip, user)
+		return "denied: country not allowed"
+	}
+	if err != nil {
+		log.Printf("Error whitelisting IP %s after DNS knock: %v", ip, err)
+		return "error: failed to whitelist IP"
+	}
+
+	policy.RecordAttempt(ip, access.MethodDNS, true)
+	log.Printf("IP %s whitelisted by DNS knock of %s until %s", ip, user, expiresAt.Format(time.RFC3339))
+
+	ports := policy.Ports
+	if ports == "" {
+		ports = "all"
+	}
+	return fmt.Sprintf("ok ip=%s ports=%s expires=%s", ip, ports, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// resolvePolicy 按服务名称返回用户的策略，DNS会改变大小写，名称不区分大小写
+func resolvePolicy(u *models.User, service string) (*access.Policy, error) {
+	if service == "" {
+		return access.UserPolicy(u, 0)
+	}
+	s, err := database.GetServiceByNameFold(service)
+	if err != nil {
+		return nil, err
+	}
+	return access.UserPolicy(u, s.ID)
+}
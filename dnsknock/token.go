@@ -0,0 +1,79 @@
+// Package dnsknock 提供一个只用于解锁的权威DNS服务：用户查询 <令牌>[.<服务>].knock.example.com，
+// 令牌由用户密钥和当前时间计算，验证通过后放行查询的来源地址，结果以TXT记录返回。
+// 用于只有DNS能够出网的受限网络
+package dnsknock
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// TokenStep 为令牌的时间步长，前后各一个步长内的令牌都被接受以容忍时钟偏差
+	TokenStep  = 30 * time.Second
+	secretSize = 32
+	// tokenSize 为截取的HMAC字节数，编码后为26个字符，可以放进一个DNS标签
+	tokenSize = 16
+)
+
+// DNS不区分大小写，令牌使用小写、无填充的base32
+var tokenEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateSecret 生成新的随机密钥，以十六进制表示
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// ParseSecret 解析十六进制密钥
+func ParseSecret(s string) ([]byte, error) {
+	secret, err := hex.DecodeString(s)
+	if err != nil || len(secret) != secretSize {
+		return nil, fmt.Errorf("secret must be %d hex characters", secretSize*2)
+	}
+	return secret, nil
+}
+
+// Token 计算指定时间和服务的令牌，service 为空表示默认登录。
+// 服务名参与计算，截获的令牌不能改用于其他服务
+func Token(secret []byte, service string, t time.Time) string {
+	var step [8]byte
+	binary.BigEndian.PutUint64(step[:], uint64(t.Unix()/int64(TokenStep/time.Second)))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("iptables-safe dns knock\x00"))
+	mac.Write([]byte(strings.ToLower(service)))
+	mac.Write([]byte{0})
+	mac.Write(step[:])
+	return tokenEncoding.EncodeToString(mac.Sum(nil)[:tokenSize])
+}
+
+// Verify 检查令牌是否与当前时间前后一个步长内的某个令牌一致
+func Verify(secret []byte, service, token string, now time.Time) bool {
+	for i := -1; i <= 1; i++ {
+		expected := Token(secret, service, now.Add(time.Duration(i)*TokenStep))
+		if hmac.Equal([]byte(expected), []byte(token)) {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryName 返回用于解锁的完整域名
+func QueryName(secret []byte, service, zone string, t time.Time) string {
+	name := Token(secret, service, t)
+	if service != "" {
+		name += "." + strings.ToLower(service)
+	}
+	return name + "." + strings.ToLower(strings.TrimSuffix(zone, "."))
+}
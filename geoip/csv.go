@@ -0,0 +1,145 @@
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ipRange 是CSV数据库中的一个地址段
+type ipRange struct {
+	start, end netip.Addr
+	country    string
+}
+
+// csvDB 是按起始地址排序的地址段列表
+type csvDB struct {
+	ranges []ipRange
+}
+
+// openCSV 读取CSV格式的国家数据库，支持以下几种常见的行格式：
+//
+//	1.0.0.0/24,AU                    网段,国家（可以有更多列）
+//	1.0.0.0,1.0.0.255,AU             起始地址,结束地址,国家（DB-IP Lite）
+//	"16777216","16777471","AU",...   十进制起始,结束,国家（IP2Location LITE）
+//
+// 无法解析的行（如表头）和国家代码为空、"-"、"ZZ" 的行会被忽略
+func openCSV(path string) (*csvDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	db := &csvDB{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rng, ok := parseRecord(record); ok {
+			db.ranges = append(db.ranges, rng)
+		}
+	}
+	if len(db.ranges) == 0 {
+		return nil, fmt.Errorf("no ranges found in %s", path)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].start.Less(db.ranges[j].start)
+	})
+	return db, nil
+}
+
+func parseRecord(record []string) (ipRange, bool) {
+	if len(record) < 2 {
+		return ipRange{}, false
+	}
+	first := strings.TrimSpace(record[0])
+
+	if prefix, err := netip.ParsePrefix(first); err == nil {
+		prefix = prefix.Masked()
+		return newRange(prefix.Addr(), lastAddr(prefix), record[1])
+	}
+
+	if len(record) < 3 {
+		return ipRange{}, false
+	}
+	start, ok1 := parseAddr(first)
+	end, ok2 := parseAddr(strings.TrimSpace(record[1]))
+	if !ok1 || !ok2 {
+		return ipRange{}, false
+	}
+	return newRange(start, end, record[2])
+}
+
+func newRange(start, end netip.Addr, country string) (ipRange, bool) {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if len(country) != 2 || country == "ZZ" {
+		return ipRange{}, false
+	}
+	start, end = start.Unmap(), end.Unmap()
+	if start.BitLen() != end.BitLen() || end.Less(start) {
+		return ipRange{}, false
+	}
+	return ipRange{start: start, end: end, country: country}, true
+}
+
+// parseAddr 解析点分/冒号格式的地址或十进制整数形式的地址
+func parseAddr(s string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr, true
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return netip.Addr{}, false
+	}
+	// IP2Location的IPv6库把IPv4地址写作 ::ffff:a.b.c.d 对应的整数，Unmap 后仍按IPv4处理
+	if n.BitLen() <= 32 {
+		var a [4]byte
+		n.FillBytes(a[:])
+		return netip.AddrFrom4(a), true
+	}
+	var a [16]byte
+	n.FillBytes(a[:])
+	return netip.AddrFrom16(a), true
+}
+
+// lastAddr 返回网段的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	a := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(a)*8; i++ {
+		a[i/8] |= 1 << (7 - uint(i%8))
+	}
+	addr, _ := netip.AddrFromSlice(a)
+	return addr
+}
+
+func (db *csvDB) country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	// 找到最后一个起始地址不大于addr的地址段
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].start)
+	}) - 1
+	if i < 0 {
+		return ""
+	}
+	rng := db.ranges[i]
+	if rng.start.BitLen() != addr.BitLen() || rng.end.Less(addr) {
+		return ""
+	}
+	return rng.country
+}
@@ -0,0 +1,91 @@
+// Package geoip 使用本地的MMDB或CSV数据库查询IP所属国家，不访问任何在线服务
+package geoip
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Database 按IP查询国家ISO代码（如 "CN"），查不到时返回空
+type Database interface {
+	Country(addr netip.Addr) string
+}
+
+type mmdbDatabase struct {
+	db *mmdb
+}
+
+func (d mmdbDatabase) Country(addr netip.Addr) string {
+	country, err := d.db.country(addr)
+	if err != nil {
+		log.Printf("GeoIP lookup of %s failed: %v", addr, err)
+	}
+	return country
+}
+
+type csvDatabase struct {
+	db *csvDB
+}
+
+func (d csvDatabase) Country(addr netip.Addr) string {
+	return d.db.country(addr)
+}
+
+// Open 按扩展名读取数据库：.csv 为CSV格式，其他按MMDB格式读取
+func Open(path string) (Database, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		db, err := openCSV(path)
+		if err != nil {
+			return nil, err
+		}
+		return csvDatabase{db}, nil
+	}
+
+	db, err := openMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return mmdbDatabase{db}, nil
+}
+
+var (
+	mu      sync.RWMutex
+	current Database
+)
+
+// Load 读取数据库并替换当前使用的数据库
+func Load(path string) error {
+	db, err := Open(path)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	current = db
+	mu.Unlock()
+	return nil
+}
+
+// Loaded 返回是否已加载数据库
+func Loaded() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current != nil
+}
+
+// Country 返回IP所属国家，未加载数据库、地址无效或查不到时返回空
+func Country(ip string) string {
+	mu.RLock()
+	db := current
+	mu.RUnlock()
+	if db == nil {
+		return ""
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	return db.Country(addr)
+}
@@ -0,0 +1,336 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// MaxMind DB 格式说明见 https://maxmind.github.io/MaxMind-DB/
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdb 是读入内存的MaxMind DB文件（GeoLite2-Country、DB-IP等）
+type mmdb struct {
+	buf        []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// dataStart 为数据段在文件中的偏移
+	dataStart uint
+	// ipv4Start 为IPv6树中 ::/96 所在的节点，IPv4地址从这里开始查找
+	ipv4Start uint
+}
+
+func openMMDB(path string) (*mmdb, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := bytes.LastIndex(buf, metadataMarker)
+	if pos < 0 {
+		return nil, errors.New("metadata not found, not a MaxMind DB file")
+	}
+	meta := &decoder{buf: buf[pos+len(metadataMarker):]}
+	value, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata")
+	}
+
+	db := &mmdb{
+		buf:        buf,
+		nodeCount:  metaUint(m, "node_count"),
+		recordSize: metaUint(m, "record_size"),
+		ipVersion:  metaUint(m, "ip_version"),
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	db.dataStart = treeSize + 16
+	if db.dataStart > uint(pos) {
+		return nil, errors.New("search tree exceeds file size")
+	}
+
+	if db.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+func metaUint(m map[string]interface{}, key string) uint {
+	if v, ok := m[key].(uint64); ok {
+		return uint(v)
+	}
+	return 0
+}
+
+// record 读取节点的左（bit=0）或右（bit=1）记录
+func (db *mmdb) record(node uint, bit uint) uint {
+	b := db.buf
+	switch db.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			return uint(b[off+3]&0xf0)<<20 | uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+		}
+		return uint(b[off+3]&0x0f)<<24 | uint(b[off+4])<<16 | uint(b[off+5])<<8 | uint(b[off+6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[off:]))
+	}
+}
+
+// country 返回地址所在国家的ISO代码，找不到时返回空
+func (db *mmdb) country(addr netip.Addr) (string, error) {
+	addr = addr.Unmap()
+	var ipBytes []byte
+	node := uint(0)
+	switch {
+	case addr.Is4() && db.ipVersion == 6:
+		a := addr.As4()
+		ipBytes = a[:]
+		node = db.ipv4Start
+	case addr.Is4():
+		a := addr.As4()
+		ipBytes = a[:]
+	case db.ipVersion == 6:
+		a := addr.As16()
+		ipBytes = a[:]
+	default:
+		return "", nil
+	}
+
+	for i := 0; i < len(ipBytes)*8 && node < db.nodeCount; i++ {
+		bit := uint(ipBytes[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node <= db.nodeCount {
+		return "", nil
+	}
+
+	offset := node - db.nodeCount - 16
+	d := &decoder{buf: db.buf[db.dataStart:]}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return "", err
+	}
+	return countryCode(value), nil
+}
+
+// countryCode 取 country.iso_code，没有时使用 registered_country.iso_code
+func countryCode(value interface{}) string {
+	m, _ := value.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := m[key].(map[string]interface{}); ok {
+			if code, ok := c["iso_code"].(string); ok && code != "" {
+				return code
+			}
+		}
+	}
+	return ""
+}
+
+// decoder 解码MaxMind DB数据段，指针偏移相对于 buf 起始
+type decoder struct {
+	buf []byte
+}
+
+// MaxMind DB 数据类型
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errCorrupt = errors.New("corrupt data section")
+
+// decode 解码 offset 处的值，返回值和下一个值的偏移
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	return d.decodeDepth(offset, 0)
+}
+
+func (d *decoder) decodeDepth(offset uint, depth int) (interface{}, uint, error) {
+	if depth > 32 || offset >= uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typeNum := uint(ctrl >> 5)
+
+	if typeNum == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decodeDepth(pointer, depth+1)
+		return value, next, err
+	}
+
+	if typeNum == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errCorrupt
+		}
+		typeNum = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size, offset, err := d.size(ctrl, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch typeNum {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errCorrupt
+			}
+			value, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decodeDepth(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	end := offset + size
+	if end > uint(len(d.buf)) {
+		return nil, 0, errCorrupt
+	}
+	data := d.buf[offset:end]
+
+	switch typeNum {
+	case typeString:
+		return string(data), end, nil
+	case typeBytes:
+		return append([]byte(nil), data...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), end, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		var n uint64
+		for _, b := range data {
+			n = n<<8 | uint64(b)
+		}
+		return n, end, nil
+	case typeInt32:
+		// 省略了前导零字节，负数总是占满4字节，按 int32 做符号扩展
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		var n uint32
+		for _, b := range data {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), end, nil
+	case typeUint128:
+		// 国家查询用不到128位整数，只保留原始字节
+		return append([]byte(nil), data...), end, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported data type %d", typeNum)
+	}
+}
+
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errCorrupt
+	}
+	b := d.buf[offset : offset+n]
+	var p uint
+	switch n {
+	case 1:
+		p = uint(ctrl&0x7)<<8 | uint(b[0])
+	case 2:
+		p = (uint(ctrl&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		p = (uint(ctrl&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		p = uint(binary.BigEndian.Uint32(b))
+	}
+	return p, offset + n, nil
+}
+
+func (d *decoder) size(ctrl byte, offset uint) (uint, uint, error) {
+	size := uint(ctrl & 0x1f)
+	if size < 29 {
+		return size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errCorrupt
+	}
+	var extra uint
+	for _, b := range d.buf[offset : offset+n] {
+		extra = extra<<8 | uint(b)
+	}
+	switch size {
+	case 29:
+		size = 29 + extra
+	case 30:
+		size = 285 + extra
+	default:
+		size = 65821 + extra
+	}
+	return size, offset + n, nil
+}
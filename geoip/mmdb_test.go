@@ -0,0 +1,215 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 以下函数按 MaxMind DB 格式编码测试数据
+
+func encodeString(s string) []byte {
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encodeMap(size int) []byte {
+	return []byte{byte(typeMap<<5 | size)}
+}
+
+func encodeUint(typeNum int, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return append([]byte{byte(typeNum<<5 | 4)}, b...)
+}
+
+// encodePointer 编码指向数据段 offset 处的指针，只支持 11 位以内的偏移
+func encodePointer(offset uint) []byte {
+	return []byte{byte(typePointer<<5) | byte(offset>>8)&0x7, byte(offset)}
+}
+
+// putRecord 按记录长度写入节点的左（bit=0）或右（bit=1）记录
+func putRecord(tree []byte, recordSize, node, bit, value uint) {
+	switch recordSize {
+	case 24:
+		off := node*6 + bit*3
+		tree[off], tree[off+1], tree[off+2] = byte(value>>16), byte(value>>8), byte(value)
+	case 28:
+		off := node * 7
+		if bit == 0 {
+			tree[off], tree[off+1], tree[off+2] = byte(value>>16), byte(value>>8), byte(value)
+			tree[off+3] = tree[off+3]&0x0f | byte(value>>20)&0xf0
+		} else {
+			tree[off+3] = tree[off+3]&0xf0 | byte(value>>24)&0x0f
+			tree[off+4], tree[off+5], tree[off+6] = byte(value>>16), byte(value>>8), byte(value)
+		}
+	default:
+		binary.BigEndian.PutUint32(tree[node*8+bit*4:], uint32(value))
+	}
+}
+
+// buildMMDB 生成一个只包含 10.0.0.0/8（DE）和 11.0.0.0/8（registered_country 为 US）的数据库。
+// IPv6 数据库把这两个网段放在 ::/96 下。11.0.0.0/8 的国家信息通过指针引用
+func buildMMDB(t *testing.T, recordSize, ipVersion uint) string {
+	t.Helper()
+
+	// 数据段：offset 0 为 {"iso_code": "DE"}，随后是两条记录
+	var data []byte
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("iso_code")...)
+	data = append(data, encodeString("DE")...)
+	deOffset := uint(len(data))
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("country")...)
+	data = append(data, encodePointer(0)...)
+	usOffset := uint(len(data))
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodeString("registered_country")...)
+	data = append(data, encodeMap(1)...)
+	data = append(data, encodePointer(1)...) // 复用 "iso_code" 键
+	data = append(data, encodeString("US")...)
+
+	// 搜索树：共同前缀的每一位占一个节点，最后一个节点按第8位分出 10 和 11
+	var bits []uint
+	if ipVersion == 6 {
+		bits = make([]uint, 96)
+	}
+	for i := 7; i >= 1; i-- {
+		bits = append(bits, uint(10>>uint(i))&1)
+	}
+	nodeCount := uint(len(bits)) + 1
+	tree := make([]byte, nodeCount*recordSize/4)
+	for node, bit := range bits {
+		putRecord(tree, recordSize, uint(node), bit, uint(node)+1)
+		putRecord(tree, recordSize, uint(node), 1-bit, nodeCount)
+	}
+	putRecord(tree, recordSize, nodeCount-1, 0, nodeCount+16+deOffset)
+	putRecord(tree, recordSize, nodeCount-1, 1, nodeCount+16+usOffset)
+
+	var meta []byte
+	meta = append(meta, metadataMarker...)
+	meta = append(meta, encodeMap(3)...)
+	meta = append(meta, encodeString("node_count")...)
+	meta = append(meta, encodeUint(typeUint32, uint32(nodeCount))...)
+	meta = append(meta, encodeString("record_size")...)
+	meta = append(meta, encodeUint(typeUint16, uint32(recordSize))...)
+	meta = append(meta, encodeString("ip_version")...)
+	meta = append(meta, encodeUint(typeUint16, uint32(ipVersion))...)
+
+	var buf []byte
+	buf = append(buf, tree...)
+	buf = append(buf, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, meta...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, buf, 0600); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+	return path
+}
+
+func TestMMDBCountry(t *testing.T) {
+	for _, ipVersion := range []uint{4, 6} {
+		for _, recordSize := range []uint{24, 28, 32} {
+			db, err := openMMDB(buildMMDB(t, recordSize, ipVersion))
+			if err != nil {
+				t.Fatalf("record size %d, IPv%d: failed to open: %v", recordSize, ipVersion, err)
+			}
+			for ip, want := range map[string]string{
+				"10.1.2.3":        "DE",
+				"11.255.0.1":      "US",
+				"::ffff:10.0.0.1": "DE",
+				"12.0.0.1":        "",
+				"192.0.2.1":       "",
+			} {
+				got, err := db.country(netip.MustParseAddr(ip))
+				if err != nil {
+					t.Errorf("record size %d, IPv%d: lookup of %s failed: %v", recordSize, ipVersion, ip, err)
+					continue
+				}
+				if got != want {
+					t.Errorf("record size %d, IPv%d: %s is %q, want %q", recordSize, ipVersion, ip, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestMMDBRecordLayout(t *testing.T) {
+	// 选取超过24位的值，检查28位记录中间半字节的拆分
+	const left, right = 0x0abcdef1, 0x0123456f
+	for _, recordSize := range []uint{24, 28, 32} {
+		mask := uint(1)<<recordSize - 1
+		db := &mmdb{buf: make([]byte, 2*recordSize/4), recordSize: recordSize}
+		putRecord(db.buf, recordSize, 1, 0, left&mask)
+		putRecord(db.buf, recordSize, 1, 1, right&mask)
+		if got := db.record(1, 0); got != left&mask {
+			t.Errorf("record size %d: left record is %#x, want %#x", recordSize, got, left&mask)
+		}
+		if got := db.record(1, 1); got != right&mask {
+			t.Errorf("record size %d: right record is %#x, want %#x", recordSize, got, right&mask)
+		}
+	}
+
+	// 28位记录的布局与规范中的示例一致
+	db := &mmdb{buf: []byte{0x23, 0x45, 0x67, 0x1f, 0x89, 0xab, 0xcd}, recordSize: 28}
+	if got := db.record(0, 0); got != 0x1234567 {
+		t.Errorf("left record is %#x, want 0x1234567", got)
+	}
+	if got := db.record(0, 1); got != 0xf89abcd {
+		t.Errorf("right record is %#x, want 0xf89abcd", got)
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	tests := []struct {
+		buf  []byte
+		want uint
+	}{
+		{[]byte{0x20 | 0x05, 0x01}, 0x501},
+		{[]byte{0x28 | 0x05, 0x01, 0x02}, 0x50102 + 2048},
+		{[]byte{0x30 | 0x05, 0x01, 0x02, 0x03}, 0x5010203 + 526336},
+		{[]byte{0x38, 0x81, 0x02, 0x03, 0x04}, 0x81020304},
+	}
+	for _, tt := range tests {
+		d := &decoder{buf: tt.buf}
+		p, next, err := d.pointer(tt.buf[0], 1)
+		if err != nil {
+			t.Errorf("%x: %v", tt.buf, err)
+			continue
+		}
+		if p != tt.want || next != uint(len(tt.buf)) {
+			t.Errorf("%x: got pointer %#x next %d, want %#x next %d", tt.buf, p, next, tt.want, len(tt.buf))
+		}
+	}
+}
+
+func TestDecodeInt32(t *testing.T) {
+	tests := []struct {
+		buf  []byte
+		want int64
+	}{
+		{[]byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xff}, -1},
+		{[]byte{0x04, 0x01, 0x80, 0x00, 0x00, 0x00}, -2147483648},
+		{[]byte{0x01, 0x01, 0xff}, 255},
+		{[]byte{0x00, 0x01}, 0},
+	}
+	for _, tt := range tests {
+		d := &decoder{buf: tt.buf}
+		value, _, err := d.decode(0)
+		if err != nil {
+			t.Errorf("%x: %v", tt.buf, err)
+			continue
+		}
+		if value != tt.want {
+			t.Errorf("%x: got %v (%T), want %d", tt.buf, value, value, tt.want)
+		}
+	}
+
+	d := &decoder{buf: []byte{0x05, 0x01, 0, 0, 0, 0, 1}}
+	if _, _, err := d.decode(0); err == nil {
+		t.Error("expected an error for a 5-byte int32")
+	}
+}
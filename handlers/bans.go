@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetBans 返回最近7天的自动封禁，包括已过期和已解封的
+func GetBans(c *gin.Context) {
+	bans, err := database.GetRecentBans(time.Now().Add(-7 * 24 * time.Hour))
+	if err != nil {
+		log.Printf("Error getting bans: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bans"})
+		return
+	}
+	if bans == nil {
+		bans = []models.Ban{}
+	}
+	c.JSON(http.StatusOK, bans)
+}
+
+// UnbanIP 解除封禁，解除过的封禁不计入之后的封禁升级
+func UnbanIP(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	ban, err := database.GetBan(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Ban not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ban"})
+		return
+	}
+
+	if err := database.LiftBan(id); err != nil {
+		log.Printf("Error lifting ban: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lift ban"})
+		return
+	}
+
+	if err := iptables.RemoveBanRule(id); err != nil {
+		log.Printf("Error removing ban from iptables: %v", err)
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	log.Printf("Ban %d of %s lifted by admin", id, ban.IP)
+	c.JSON(http.StatusOK, gin.H{"message": "Ban lifted successfully"})
+}
+
+func GetBanPolicy(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ban_after_lockouts": config.BanAfterLockouts,
+		"ban_window_minutes": config.BanWindowMinutes,
+		"ban_base_minutes":   config.BanBaseMinutes,
+		"ban_max_minutes":    config.BanMaxMinutes,
+	})
+}
+
+// UpdateBanPolicy 设置自动封禁策略，ban_after_lockouts 为0时关闭自动封禁
+func UpdateBanPolicy(c *gin.Context) {
+	var req struct {
+		BanAfterLockouts int `json:"ban_after_lockouts"`
+		BanWindowMinutes int `json:"ban_window_minutes"`
+		BanBaseMinutes   int `json:"ban_base_minutes"`
+		BanMaxMinutes    int `json:"ban_max_minutes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.BanAfterLockouts < 0 || req.BanWindowMinutes <= 0 || req.BanBaseMinutes <= 0 ||
+		req.BanMaxMinutes < req.BanBaseMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ban policy"})
+		return
+	}
+
+	policy := models.Config{
+		BanAfterLockouts: req.BanAfterLockouts,
+		BanWindowMinutes: req.BanWindowMinutes,
+		BanBaseMinutes:   req.BanBaseMinutes,
+		BanMaxMinutes:    req.BanMaxMinutes,
+	}
+	if err := database.UpdateBanPolicy(policy); err != nil {
+		log.Printf("Error updating ban policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ban policy updated successfully"})
+}
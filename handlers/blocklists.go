@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/blocklist"
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultBlocklistRefreshMinutes = 1440
+	minBlocklistRefreshMinutes     = 5
+)
+
+type blocklistRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Format         string `json:"format" binding:"required"`
+	Location       string `json:"location" binding:"required"`
+	RefreshMinutes int    `json:"refresh_minutes"`
+	Enabled        *bool  `json:"enabled"`
+}
+
+// toSource 校验请求并填充默认值，enabled 未提供时视为启用
+func (r *blocklistRequest) toSource() (models.BlocklistSource, string) {
+	s := models.BlocklistSource{
+		Name:           strings.TrimSpace(r.Name),
+		Format:         r.Format,
+		Location:       strings.TrimSpace(r.Location),
+		RefreshMinutes: r.RefreshMinutes,
+		Enabled:        r.Enabled == nil || *r.Enabled,
+	}
+	if s.Name == "" || s.Location == "" {
+		return s, "Name and location are required"
+	}
+	if !blocklist.IsValidFormat(s.Format) {
+		return s, "Unknown format"
+	}
+	if s.RefreshMinutes == 0 {
+		s.RefreshMinutes = defaultBlocklistRefreshMinutes
+	}
+	if s.RefreshMinutes < minBlocklistRefreshMinutes {
+		return s, "Refresh interval must be at least 5 minutes"
+	}
+	return s, ""
+}
+
+func GetBlocklists(c *gin.Context) {
+	sources, err := database.GetAllBlocklistSources()
+	if err != nil {
+		log.Printf("Error getting blocklists: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocklists"})
+		return
+	}
+	if sources == nil {
+		sources = []models.BlocklistSource{}
+	}
+	c.JSON(http.StatusOK, sources)
+}
+
+// AddBlocklist 添加列表并在后台进行首次导入
+func AddBlocklist(c *gin.Context) {
+	var req blocklistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	source, msg := req.toSource()
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	id, err := database.AddBlocklistSource(source)
+	if err != nil {
+		log.Printf("Error adding blocklist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add blocklist"})
+		return
+	}
+	source.ID = id
+
+	if source.Enabled {
+		enableBlocklist(source)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Blocklist added, import started", "id": id})
+}
+
+// enableBlocklist 插入列表的防火墙规则并在后台导入
+func enableBlocklist(source models.BlocklistSource) {
+	if err := iptables.ApplyBlocklistRule(source.ID); err != nil {
+		log.Printf("Error adding blocklist rule: %v", err)
+		database.RecordBlocklistFailure(source.ID, err.Error(), time.Now())
+		return
+	}
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	go func() {
+		if err := blocklist.Refresh(source); err != nil {
+			log.Printf("%v", err)
+		}
+	}()
+}
+
+func UpdateBlocklist(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req blocklistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	source, msg := req.toSource()
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	source.ID = id
+
+	existing, err := database.GetBlocklistSource(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocklist"})
+		return
+	}
+
+	if err := database.UpdateBlocklistSource(source); err != nil {
+		log.Printf("Error updating blocklist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update blocklist"})
+		return
+	}
+
+	changed := existing.Format != source.Format || existing.Location != source.Location
+	switch {
+	case !source.Enabled && existing.Enabled:
+		if err := iptables.RemoveBlocklist(id); err != nil {
+			log.Printf("Error removing blocklist: %v", err)
+		}
+		if err := iptables.SaveRules(); err != nil {
+			log.Printf("Error saving iptables rules: %v", err)
+		}
+	case source.Enabled && (!existing.Enabled || changed):
+		enableBlocklist(source)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Blocklist updated successfully"})
+}
+
+// RefreshBlocklist 立即重新导入列表并返回结果
+func RefreshBlocklist(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	source, err := database.GetBlocklistSource(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocklist"})
+		return
+	}
+	if !source.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Blocklist is disabled"})
+		return
+	}
+
+	if err := blocklist.Refresh(*source); err != nil {
+		log.Printf("%v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Blocklist refreshed successfully"})
+}
+
+func DeleteBlocklist(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetBlocklistSource(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocklist"})
+		return
+	}
+
+	if err := database.DeleteBlocklistSource(id); err != nil {
+		log.Printf("Error deleting blocklist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete blocklist"})
+		return
+	}
+
+	if err := iptables.RemoveBlocklist(id); err != nil {
+		log.Printf("Error removing blocklist: %v", err)
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Blocklist deleted successfully"})
+}
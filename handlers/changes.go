@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/models"
+	"iptables-safe/notify"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// submitWhitelistChange 把需要双人审批的白名单条目保存为待审批变更，不修改防火墙
+func submitWhitelistChange(c *gin.Context, entry models.WhitelistIP, config *models.Config) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Error encoding pending change: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	summary := "Add permanent whitelist entry " + whitelistDetail(entry)
+	if !entry.IsPermanent {
+		summary = fmt.Sprintf("Add whitelist entry %s until %s", whitelistDetail(entry),
+			entry.ExpiresAt.Format(time.RFC3339))
+	}
+
+	now := time.Now()
+	change := models.PendingChange{
+		Kind:             access.ChangeWhitelistAdd,
+		Summary:          summary,
+		Payload:          payload,
+		RequestedBy:      getClientIP(c),
+		RequestedSession: adminSession(c),
+		CreatedAt:        now,
+		ExpiresAt:        access.PendingExpiry(config, now),
+	}
+	if entry.Description != "" {
+		change.Summary += " (" + entry.Description + ")"
+	}
+	id, err := database.AddPendingChange(change)
+	if err != nil {
+		log.Printf("Error adding pending change: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit change"})
+		return
+	}
+	change.ID = id
+
+	log.Printf("Pending change %d submitted from %s: %s", id, change.RequestedBy, change.Summary)
+	notify.Send("pending_change.created",
+		fmt.Sprintf("Pending change #%d needs a second approver: %s", id, change.Summary), change)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Change is pending approval by a second administrator",
+		"pending_id": id,
+		"expires":    change.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// adminSession 返回当前管理员会话的哈希，不保存会话令牌本身。
+// 经过 AdminAuthMiddleware 的请求都携带服务端签发的令牌，无法冒用其他会话
+func adminSession(c *gin.Context) string {
+	token, _ := c.Cookie("admin_token")
+	if token == "" {
+		return ""
+	}
+	return hashToken(token)
+}
+
+// GetPendingChanges 返回待审批的变更和最近7天内的其他变更
+func GetPendingChanges(c *gin.Context) {
+	changes, err := database.GetPendingChanges(time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		log.Printf("Error getting pending changes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get pending changes"})
+		return
+	}
+	if changes == nil {
+		changes = []models.PendingChange{}
+	}
+	c.JSON(http.StatusOK, changes)
+}
+
+// getOpenChange 读取路径中的变更，变更不存在或已处理时直接写入错误响应并返回nil
+func getOpenChange(c *gin.Context) *models.PendingChange {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return nil
+	}
+
+	change, err := database.GetPendingChange(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Change not found"})
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get change"})
+		return nil
+	}
+	if change.Status != access.ChangePending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change has already been decided"})
+		return nil
+	}
+	return change
+}
+
+// ApprovePendingChange 由第二位管理员用审批人密码批准并执行变更
+func ApprovePendingChange(c *gin.Context) {
+	var req struct {
+		Approver string `json:"approver" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	approver := strings.TrimSpace(req.Approver)
+	if approver == "" || utf8.RuneCountInString(approver) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Approver must be 1-64 characters"})
+		return
+	}
+
+	change := getOpenChange(c)
+	if change == nil {
+		return
+	}
+	// 提交者不能批准自己的变更，即使持有审批人密码
+	if change.RequestedBy == getClientIP(c) || change.RequestedSession == adminSession(c) {
+		log.Printf("Rejected approval of pending change %d from %s: same administrator as submitter", change.ID, getClientIP(c))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Changes must be approved by a different administrator"})
+		return
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !access.CheckApprover(config, req.Password) {
+		log.Printf("Rejected approval of pending change %d from %s: invalid approver password", change.ID, getClientIP(c))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid approver password"})
+		return
+	}
+
+	if change.Kind != access.ChangeWhitelistAdd {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported change"})
+		return
+	}
+	var entry models.WhitelistIP
+	if err := json.Unmarshal(change.Payload, &entry); err != nil {
+		log.Printf("Error decoding pending change %d: %v", change.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	ok, err := database.DecidePendingChange(change.ID, access.ChangeApproved, approver, strings.TrimSpace(req.Note))
+	if err != nil {
+		log.Printf("Error approving pending change: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve change"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change has already been decided"})
+		return
+	}
+
+	if _, err := addWhitelistEntry(entry, "approved by "+approver); err != nil {
+		if err := database.ReopenPendingChange(change.ID); err != nil {
+			log.Printf("Error reopening pending change: %v", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Pending change %d approved by %s: %s", change.ID, approver, change.Summary)
+	notify.Send("pending_change.approved",
+		fmt.Sprintf("Pending change #%d approved by %s: %s", change.ID, approver, change.Summary), change)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Change approved", "ip": entry.IP})
+}
+
+// RejectPendingChange 拒绝变更，提交者也可以用它撤回自己的变更
+func RejectPendingChange(c *gin.Context) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	change := getOpenChange(c)
+	if change == nil {
+		return
+	}
+
+	ok, err := database.DecidePendingChange(change.ID, access.ChangeRejected, getClientIP(c), strings.TrimSpace(req.Note))
+	if err != nil {
+		log.Printf("Error rejecting pending change: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject change"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change has already been decided"})
+		return
+	}
+
+	log.Printf("Pending change %d rejected: %s", change.ID, change.Summary)
+	notify.Send("pending_change.rejected",
+		fmt.Sprintf("Pending change #%d rejected: %s", change.ID, change.Summary), change)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Change rejected"})
+}
+
+func GetApprovalPolicy(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"four_eyes_permanent":   config.FourEyesPermanent,
+		"pending_ttl_hours":     config.PendingTTLHours,
+		"four_eyes_max_hours":   config.FourEyesMaxHours,
+		"four_eyes_min_prefix":  config.FourEyesMinPrefix,
+		"approver_password_set": config.ApproverPassword != "",
+	})
+}
+
+// UpdateApprovalPolicy 设置双人审批策略。开启前必须先设置审批人密码，
+// 关闭需要审批人密码，避免一位管理员自行绕过审批
+func UpdateApprovalPolicy(c *gin.Context) {
+	var req struct {
+		FourEyesPermanent bool   `json:"four_eyes_permanent"`
+		PendingTTLHours   int    `json:"pending_ttl_hours"`
+		FourEyesMaxHours  int    `json:"four_eyes_max_hours"`
+		FourEyesMinPrefix int    `json:"four_eyes_min_prefix"`
+		ApproverPassword  string `json:"approver_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.PendingTTLHours < 1 || req.PendingTTLHours > 24*7 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pending TTL must be between 1 and 168 hours"})
+		return
+	}
+	if req.FourEyesMaxHours < 1 || req.FourEyesMaxHours > 24*365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Maximum unapproved duration must be between 1 and 8760 hours"})
+		return
+	}
+	if req.FourEyesMinPrefix < 0 || req.FourEyesMinPrefix > 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Minimum unapproved prefix must be between 0 and 32"})
+		return
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	if req.FourEyesPermanent && config.ApproverPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set the approver password on the server with -set-approver-password"})
+		return
+	}
+	// 关闭审批或放宽无需批准的时长和网段都需要审批人密码
+	loosened := !req.FourEyesPermanent || req.FourEyesMaxHours > config.FourEyesMaxHours ||
+		req.FourEyesMinPrefix < config.FourEyesMinPrefix
+	if config.FourEyesPermanent && loosened && !access.CheckApprover(config, req.ApproverPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid approver password"})
+		return
+	}
+
+	if err := database.UpdateApprovalPolicy(req.FourEyesPermanent, req.PendingTTLHours, req.FourEyesMaxHours,
+		req.FourEyesMinPrefix); err != nil {
+		log.Printf("Error updating approval policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+
+	log.Printf("Approval policy updated from %s: four-eyes %v, max unapproved duration %dh, min unapproved prefix /%d",
+		getClientIP(c), req.FourEyesPermanent, req.FourEyesMaxHours, req.FourEyesMinPrefix)
+	c.JSON(http.StatusOK, gin.H{"message": "Approval policy updated successfully"})
+}
+
+// UpdateApproverPassword 修改审批人密码，需要提供当前的审批人密码。
+// 首次设置只能在服务器上用 -set-approver-password 完成
+func UpdateApproverPassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if config.ApproverPassword == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set the approver password on the server with -set-approver-password"})
+		return
+	}
+	if !access.CheckApprover(config, req.CurrentPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid approver password"})
+		return
+	}
+	// 与管理员密码相同时一个人就能完成审批
+	if bcrypt.CompareHashAndPassword([]byte(config.AdminPassword), []byte(req.NewPassword)) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Approver password must differ from the admin password"})
+		return
+	}
+
+	if err := database.UpdateApproverPassword(req.NewPassword); err != nil {
+		log.Printf("Error updating approver password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Approver password updated successfully"})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// entryConnections 是一个白名单条目及其当前的活跃连接
+type entryConnections struct {
+	Entry         models.WhitelistIP    `json:"entry"`
+	Connections   []iptables.Connection `json:"connections"`
+	BytesSent     int64                 `json:"bytes_sent"`
+	BytesReceived int64                 `json:"bytes_received"`
+}
+
+// GetConnections 读取conntrack并按白名单条目分组返回活跃连接，
+// 只返回至少有一条连接的有效条目
+func GetConnections(c *gin.Context) {
+	conns, err := iptables.ListConnections()
+	if err != nil {
+		log.Printf("Error listing connections: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read connection tracking table"})
+		return
+	}
+
+	entries, err := database.GetAllWhitelistIPs()
+	if err != nil {
+		log.Printf("Error getting whitelist IPs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get IPs"})
+		return
+	}
+
+	now := time.Now()
+	groups := []*entryConnections{}
+	matched := make([]bool, len(conns))
+	for _, entry := range entries {
+		if !entry.IsPermanent && !entry.ExpiresAt.After(now) {
+			continue
+		}
+		portRules, err := iptables.ParsePorts(entry.Ports)
+		if err != nil {
+			continue
+		}
+
+		group := &entryConnections{Entry: entry}
+		for i, conn := range conns {
+			// 同一连接只归属于第一个匹配的条目
+			if matched[i] || !conn.MatchesEntry(entry.IP, portRules) {
+				continue
+			}
+			matched[i] = true
+			group.Connections = append(group.Connections, conn)
+			group.BytesSent += conn.BytesSent
+			group.BytesReceived += conn.BytesReceived
+		}
+		if len(group.Connections) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": groups, "total": len(conns)})
+}
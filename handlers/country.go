@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginAttemptsLimit 为登录记录接口返回的最大条数
+const loginAttemptsLimit = 200
+
+func GetCountryPolicy(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"mode":            config.CountryMode,
+		"countries":       config.Countries,
+		"database_loaded": geoip.Loaded(),
+	})
+}
+
+// UpdateCountryPolicy 设置按国家限制用户登录和解锁的策略，mode 为空时不限制
+func UpdateCountryPolicy(c *gin.Context) {
+	var req struct {
+		Mode      string `json:"mode"`
+		Countries string `json:"countries"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if !access.IsValidCountryMode(req.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode"})
+		return
+	}
+
+	countries, err := access.NormalizeCountries(req.Countries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// allow 模式下列表为空会拒绝所有公网地址
+	if req.Mode == access.CountryAllow && countries == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one country is required in allow mode"})
+		return
+	}
+
+	if err := database.UpdateCountryPolicy(req.Mode, countries); err != nil {
+		log.Printf("Error updating country policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+
+	if req.Mode != access.CountryAny && !geoip.Loaded() {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Country policy updated, but no GeoIP database is loaded",
+			"warning": true,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Country policy updated successfully"})
+}
+
+// GetLoginAttempts 返回最近的用户登录尝试及来源国家
+func GetLoginAttempts(c *gin.Context) {
+	attempts, err := database.GetRecentLoginAttempts(loginAttemptsLimit)
+	if err != nil {
+		log.Printf("Error getting login attempts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get login attempts"})
+		return
+	}
+	if attempts == nil {
+		attempts = []models.LoginAttempt{}
+	}
+	c.JSON(http.StatusOK, attempts)
+}
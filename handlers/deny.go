@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetDenyEntries(c *gin.Context) {
+	entries, err := database.GetAllDenyEntries()
+	if err != nil {
+		log.Printf("Error getting deny entries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deny list"})
+		return
+	}
+	if entries == nil {
+		entries = []models.DenyEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// AddDenyEntry 拉黑一个IP或网段，规则优先于8888端口和白名单生效。
+// 不允许拉黑当前管理员自己的地址，避免把自己锁在外面
+func AddDenyEntry(c *gin.Context) {
+	var req struct {
+		Address string `json:"address" binding:"required"`
+		Reason  string `json:"reason"`
+		// ExpiresAt 为RFC3339格式，留空表示长期有效
+		ExpiresAt string `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	entry := models.DenyEntry{
+		Address: strings.TrimSpace(req.Address),
+		Reason:  req.Reason,
+	}
+	if !iptables.IsValidDenyAddress(entry.Address) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address or CIDR"})
+		return
+	}
+	if iptables.AddressContains(entry.Address, getClientIP(c)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refusing to deny your own address"})
+		return
+	}
+
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry time"})
+			return
+		}
+		entry.ExpiresAt = &expiresAt
+	}
+
+	id, err := database.AddDenyEntry(entry)
+	if err != nil {
+		log.Printf("Error adding deny entry to database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add deny entry"})
+		return
+	}
+
+	if err := iptables.ApplyDenyRule(id, entry.Address); err != nil {
+		log.Printf("Error adding deny entry to iptables: %v", err)
+		database.DeleteDenyEntry(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update firewall"})
+		return
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deny entry added successfully", "id": id})
+}
+
+func DeleteDenyEntry(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetDenyEntry(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deny entry not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deny entry"})
+		return
+	}
+
+	if err := database.DeleteDenyEntry(id); err != nil {
+		log.Printf("Error deleting deny entry from database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deny entry"})
+		return
+	}
+
+	if err := iptables.RemoveDenyRule(id); err != nil {
+		log.Printf("Error removing deny entry from iptables: %v", err)
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deny entry deleted successfully"})
+}
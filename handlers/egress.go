@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetEgressRules(c *gin.Context) {
+	rules, err := database.GetAllEgressRules()
+	if err != nil {
+		log.Printf("Error getting egress rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get egress rules"})
+		return
+	}
+	if rules == nil {
+		rules = []models.EgressRule{}
+	}
+	for i := range rules {
+		if !iptables.IsValidHostname(rules[i].Destination) {
+			continue
+		}
+		addrs, err := database.GetEgressAddresses(rules[i].ID)
+		if err != nil {
+			log.Printf("Error getting egress addresses: %v", err)
+			continue
+		}
+		rules[i].Addresses = addrs
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func AddEgressRule(c *gin.Context) {
+	var req struct {
+		Destination string `json:"destination" binding:"required"`
+		Ports       string `json:"ports"`
+		Description string `json:"description"`
+		// ExpiresAt 为RFC3339格式，留空表示长期有效
+		ExpiresAt string `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	rule := models.EgressRule{
+		Destination: strings.TrimSpace(req.Destination),
+		Description: req.Description,
+	}
+	if !iptables.IsValidEgressDestination(rule.Destination) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid destination"})
+		return
+	}
+
+	ports, err := iptables.NormalizePorts(req.Ports)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.Ports = ports
+
+	if req.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil || !expiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry time"})
+			return
+		}
+		rule.ExpiresAt = &expiresAt
+	}
+
+	id, err := database.AddEgressRule(rule)
+	if err != nil {
+		log.Printf("Error adding egress rule to database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add egress rule"})
+		return
+	}
+
+	if err := iptables.ApplyEgressRule(id, rule.Destination, rule.Ports); err != nil {
+		log.Printf("Error adding egress rule to iptables: %v", err)
+		database.DeleteEgressRule(id)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update firewall"})
+		return
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Egress rule added successfully", "id": id})
+}
+
+func DeleteEgressRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetEgressRule(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Egress rule not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get egress rule"})
+		return
+	}
+
+	if err := database.DeleteEgressRule(id); err != nil {
+		log.Printf("Error deleting egress rule from database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete egress rule"})
+		return
+	}
+
+	if err := iptables.RemoveEgressRule(id); err != nil {
+		log.Printf("Error removing egress rule from iptables: %v", err)
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Egress rule deleted successfully"})
+}
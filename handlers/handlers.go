@@ -1,27 +1,36 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"iptables-safe/access"
 	"iptables-safe/database"
+	"iptables-safe/geoip"
 	"iptables-safe/iptables"
+	"iptables-safe/models"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	MaxFailedAttempts     = 5
-	LockoutDuration       = 15 * time.Minute
-	TempWhitelistDuration = 24 * time.Hour
+	TempWhitelistDuration = access.DefaultGrantDuration
 )
 
 func UserLoginPage(c *gin.Context) {
-	c.HTML(http.StatusOK, "login.html", nil)
+	// 登录页按默认登录的最长时长过滤可选的授权时长
+	maxMinutes := int(access.DefaultGrantDuration / time.Minute)
+	if policy, err := access.ResolvePolicy(0); err == nil {
+		maxMinutes = int(policy.GrantDuration / time.Minute)
+	}
+	c.HTML(http.StatusOK, "login.html", gin.H{"UserMaxDurationMinutes": maxMinutes})
 }
 
 func UserLogin(c *gin.Context) {
@@ -33,20 +42,11 @@ func UserLogin(c *gin.Context) {
 		return
 	}
 
-	failedAttempts, err := database.GetRecentFailedAttempts(clientIP, LockoutDuration)
-	if err != nil {
-		log.Printf("Error checking failed attempts: %v", err)
-	}
-
-	if failedAttempts >= MaxFailedAttempts {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"error": "Too many failed attempts. Please try again later.",
-		})
-		return
-	}
-
 	var req struct {
-		Password string `json:"password" binding:"required"`
+		Password  string `json:"password" binding:"required"`
+		ServiceID int    `json:"service_id"`
+		// DurationMinutes 为希望的授权时长，0表示策略允许的最长时长
+		DurationMinutes int `json:"duration_minutes"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -54,43 +54,73 @@ func UserLogin(c *gin.Context) {
 		return
 	}
 
-	config, err := database.GetConfig()
+	policy, err := access.ResolvePolicy(req.ServiceID)
 	if err != nil {
-		log.Printf("Error getting config: %v", err)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service"})
+			return
+		}
+		log.Printf("Error getting login policy: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(config.UserPassword), []byte(req.Password))
-	if err != nil {
-		database.RecordLoginAttempt(clientIP, false)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
+	if err := policy.LimitDuration(req.DurationMinutes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Requested duration must be at most %s", formatDuration(policy.GrantDuration)),
+		})
 		return
 	}
 
-	database.RecordLoginAttempt(clientIP, true)
+	// 在校验密码之前拒绝不允许的国家，不透露密码是否正确
+	if country, err := access.CheckCountry(clientIP); err != nil {
+		if errors.Is(err, access.ErrCountryDenied) {
+			log.Printf("Rejected login from %s (country %q)", clientIP, country)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access from your country is not allowed"})
+			return
+		}
+		log.Printf("Error checking country policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
 
-	expiresAt := time.Now().Add(TempWhitelistDuration)
-	if err := database.AddWhitelistIP(clientIP, "User login", false, expiresAt); err != nil {
-		log.Printf("Error adding IP to database: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to whitelist IP"})
+	if policy.IsLockedOut(clientIP) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many failed attempts. Please try again later.",
+		})
 		return
 	}
 
-	if err := iptables.AddIPToWhitelist(clientIP); err != nil {
-		log.Printf("Error adding IP to iptables: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update firewall"})
+	if !policy.CheckPassword(clientIP, req.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
 		return
 	}
 
-	if err := iptables.SaveRules(); err != nil {
-		log.Printf("Error saving iptables rules: %v", err)
+	description := "User login"
+	if policy.ServiceName != "" {
+		description = "User login: " + policy.ServiceName
 	}
 
+	expiresAt, err := access.Grant(clientIP, access.MethodPassword, policy, description)
+	if errors.Is(err, access.ErrCountryDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access from your country is not allowed"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error whitelisting IP %s: %v", clientIP, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to whitelist IP"})
+		return
+	}
+
+	issueExtendToken(c, clientIP, policy.ServiceID)
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Access granted. Your IP has been whitelisted for 24 hours.",
+		// 已有更晚的过期时间时授权不会被缩短，按实际的过期时间提示
+		"message": fmt.Sprintf("Access granted. Your IP has been whitelisted for %s.",
+			formatDuration(time.Until(expiresAt).Round(time.Minute))),
 		"ip":      clientIP,
 		"expires": expiresAt.Format(time.RFC3339),
+		"ports":   policy.Ports,
+		"service": policy.ServiceName,
 	})
 }
 
@@ -121,8 +151,13 @@ func AdminLogin(c *gin.Context) {
 		return
 	}
 
-	token := generateToken()
-	c.SetCookie("admin_token", token, 3600, "/", "", false, true)
+	token, err := newAdminSession()
+	if err != nil {
+		log.Printf("Error creating admin session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.SetCookie("admin_token", token, int(adminSessionTTL.Seconds()), "/", "", false, true)
 	c.JSON(http.StatusOK, gin.H{"message": "Login successful"})
 }
 
@@ -137,14 +172,26 @@ func GetWhitelistIPs(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get whitelist"})
 		return
 	}
+
+	totals, err := database.GetTrafficTotals()
+	if err != nil {
+		log.Printf("Error getting traffic totals: %v", err)
+	}
+	for i := range ips {
+		ips[i].Traffic = totals[ips[i].ID]
+	}
 	c.JSON(http.StatusOK, ips)
 }
 
 func AddWhitelistIP(c *gin.Context) {
 	var req struct {
-		IP          string `json:"ip" binding:"required"`
+		IP          string `json:"ip"`
+		Hostname    string `json:"hostname"`
 		Description string `json:"description"`
 		IsPermanent bool   `json:"is_permanent"`
+		Ports       string `json:"ports"`
+		// ExpiresAt 为临时条目的过期时间（RFC3339），为空时为24小时后
+		ExpiresAt string `json:"expires_at"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -152,19 +199,160 @@ func AddWhitelistIP(c *gin.Context) {
 		return
 	}
 
-	var expiresAt time.Time
+	expiresAt := time.Now().Add(TempWhitelistDuration)
+	if !req.IsPermanent && req.ExpiresAt != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry time"})
+			return
+		}
+		if !t.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry time must be in the future"})
+			return
+		}
+		expiresAt = t
+	}
+
+	ports, err := iptables.NormalizePorts(req.Ports)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry := models.WhitelistIP{
+		IP:          strings.TrimSpace(req.IP),
+		Hostname:    strings.TrimSpace(req.Hostname),
+		Description: req.Description,
+		IsPermanent: req.IsPermanent,
+		Ports:       ports,
+	}
+
+	// 按域名添加时先解析出当前地址，之后由后台任务跟随域名变化
+	if entry.Hostname != "" {
+		if !iptables.IsValidHostname(entry.Hostname) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hostname"})
+			return
+		}
+		entry.IP, err = iptables.ResolveHostname(entry.Hostname)
+		if err != nil {
+			log.Printf("Error resolving %s: %v", entry.Hostname, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to resolve hostname"})
+			return
+		}
+	}
+	if entry.IP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "IP or hostname is required"})
+		return
+	}
+	// 在进入审批队列或写入数据库之前校验，避免批准无效的条目或留下没有规则的记录
+	if !iptables.IsValidWhitelistIP(entry.IP) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+		return
+	}
 	if !req.IsPermanent {
-		expiresAt = time.Now().Add(TempWhitelistDuration)
+		entry.ExpiresAt = expiresAt
 	}
+	// 管理员添加的条目不受国家策略限制，只记录国家用于显示
+	entry.Country = geoip.Country(entry.IP)
 
-	if err := database.AddWhitelistIP(req.IP, req.Description, req.IsPermanent, expiresAt); err != nil {
-		log.Printf("Error adding IP to database: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add IP"})
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if access.RequiresApproval(entry, config) {
+		submitWhitelistChange(c, entry, config)
 		return
 	}
 
-	if err := iptables.AddIPToWhitelist(req.IP); err != nil {
+	if _, err := addWhitelistEntry(entry, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "IP added successfully", "ip": entry.IP})
+}
+
+// addWhitelistEntry 写入数据库、插入防火墙规则并记录历史，note 附加在历史记录后面。
+// 返回的错误可以直接显示给客户端
+func addWhitelistEntry(entry models.WhitelistIP, note string) (int, error) {
+	if !iptables.IsValidWhitelistIP(entry.IP) {
+		return 0, errors.New("Invalid IP address")
+	}
+
+	saved, err := database.AddWhitelistIP(entry)
+	if err != nil {
+		log.Printf("Error adding IP to database: %v", err)
+		return 0, errors.New("Failed to add IP")
+	}
+	id := saved.ID
+
+	if err := iptables.AddIPToWhitelist(id, saved.IP, saved.Ports); err != nil {
 		log.Printf("Error adding IP to iptables: %v", err)
+		return 0, errors.New("Failed to update firewall")
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	detail := whitelistDetail(entry)
+	if note != "" {
+		detail += " (" + note + ")"
+	}
+	database.AddWhitelistHistory(id, "created", detail)
+	return id, nil
+}
+
+func whitelistDetail(entry models.WhitelistIP) string {
+	if entry.Hostname != "" {
+		return entry.Hostname + " -> " + entry.IP
+	}
+	return entry.IP
+}
+
+func UpdateWhitelistIP(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		Description string `json:"description"`
+		Ports       string `json:"ports"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ports, err := iptables.NormalizePorts(req.Ports)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := database.GetWhitelistIP(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get IP"})
+		return
+	}
+
+	if err := database.UpdateWhitelistIP(id, req.Description, ports); err != nil {
+		log.Printf("Error updating IP in database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update IP"})
+		return
+	}
+
+	if err := iptables.AddIPToWhitelist(id, entry.IP, ports); err != nil {
+		log.Printf("Error updating IP in iptables: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update firewall"})
 		return
 	}
@@ -173,51 +361,145 @@ func AddWhitelistIP(c *gin.Context) {
 		log.Printf("Error saving iptables rules: %v", err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "IP added successfully"})
+	database.AddWhitelistHistory(id, "updated", "ports: "+formatPortsDetail(ports))
+
+	c.JSON(http.StatusOK, gin.H{"message": "IP updated successfully"})
 }
 
-func DeleteWhitelistIP(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
+func formatPortsDetail(ports string) string {
+	if ports == "" {
+		return "all"
+	}
+	return ports
+}
+
+func GetWhitelistHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
 		return
 	}
 
-	ips, err := database.GetAllWhitelistIPs()
+	history, err := database.GetWhitelistHistory(id)
+	if err != nil {
+		log.Printf("Error getting whitelist history: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history"})
+		return
+	}
+	if history == nil {
+		history = []models.WhitelistHistory{}
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// GetWhitelistTraffic 返回条目最近 hours 小时（默认24，最多为保留期）的流量采样
+func GetWhitelistTraffic(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	hours, err := strconv.Atoi(c.DefaultQuery("hours", "24"))
+	maxHours := int(iptables.TrafficRetention / time.Hour)
+	if err != nil || hours <= 0 || hours > maxHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("hours must be between 1 and %d", maxHours)})
+		return
+	}
+
+	samples, err := database.GetTrafficSamples(id, time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		log.Printf("Error getting traffic samples: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get traffic"})
+		return
+	}
+	if samples == nil {
+		samples = []models.TrafficSample{}
+	}
+	c.JSON(http.StatusOK, samples)
+}
+
+// DisconnectWhitelistIP 立即断开白名单条目已建立的连接，条目本身仍然有效，客户端可以重新连接
+func DisconnectWhitelistIP(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	entry, err := database.GetWhitelistIP(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get IP"})
 		return
 	}
 
-	var targetIP string
-	for _, ip := range ips {
-		if ip.ID == id {
-			targetIP = ip.IP
-			break
+	filters, err := iptables.EntryConnFilters(entry.IP, entry.Ports)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	total := 0
+	for _, f := range filters {
+		deleted, err := iptables.FlushConnections(f)
+		if err != nil {
+			log.Printf("Error flushing connections of %s: %v", f, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flush connections, is conntrack installed?"})
+			return
 		}
+		total += deleted
 	}
 
-	if targetIP == "" {
-		c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
+	log.Printf("Disconnected %d connection(s) of whitelist entry %d (%s)", total, id, entry.IP)
+	database.AddWhitelistHistory(id, "disconnected", fmt.Sprintf("%d connection(s) closed", total))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Connections closed", "disconnected": total})
+}
+
+func DeleteWhitelistIP(c *gin.Context) {
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
 		return
 	}
 
-	if err := database.DeleteWhitelistIP(id); err != nil {
+	if _, err := database.GetWhitelistIP(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get IP"})
+		return
+	}
+
+	if err := removeWhitelistEntry(id); err != nil {
 		log.Printf("Error deleting IP from database: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete IP"})
 		return
 	}
 
-	if err := iptables.RemoveIPFromWhitelist(targetIP); err != nil {
+	c.JSON(http.StatusOK, gin.H{"message": "IP deleted successfully"})
+}
+
+// removeWhitelistEntry 删除条目并撤销其防火墙规则
+func removeWhitelistEntry(id int) error {
+	if err := database.DeleteWhitelistIP(id); err != nil {
+		return err
+	}
+
+	if err := iptables.RemoveIPFromWhitelist(id); err != nil {
 		log.Printf("Error removing IP from iptables: %v", err)
 	}
 
 	if err := iptables.SaveRules(); err != nil {
 		log.Printf("Error saving iptables rules: %v", err)
 	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "IP deleted successfully"})
+	return nil
 }
 
 func UpdateUserPassword(c *gin.Context) {
@@ -239,6 +521,60 @@ func UpdateUserPassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User password updated successfully"})
 }
 
+func GetUserPolicy(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	maxDuration := config.UserMaxDurationMinutes
+	if maxDuration <= 0 {
+		maxDuration = int(access.DefaultGrantDuration / time.Minute)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ports":                config.UserPorts,
+		"max_duration_minutes": maxDuration,
+		"max_ips_per_user":     config.MaxIPsPerUser,
+	})
+}
+
+// UpdateUserPolicy 设置用户登录后放行的端口、最长授权时长和每个用户同时放行的IP数，只影响之后的登录
+func UpdateUserPolicy(c *gin.Context) {
+	var req struct {
+		Ports              string `json:"ports"`
+		MaxDurationMinutes int    `json:"max_duration_minutes"`
+		MaxIPsPerUser      int    `json:"max_ips_per_user"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	ports, err := iptables.NormalizePorts(req.Ports)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.MaxDurationMinutes <= 0 {
+		req.MaxDurationMinutes = int(access.DefaultGrantDuration / time.Minute)
+	}
+	if req.MaxIPsPerUser < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Max IPs per user must not be negative"})
+		return
+	}
+
+	if err := database.UpdateUserPolicy(ports, req.MaxDurationMinutes, req.MaxIPsPerUser); err != nil {
+		log.Printf("Error updating user policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User policy updated successfully"})
+}
+
 func UpdateAdminPassword(c *gin.Context) {
 	var req struct {
 		NewPassword string `json:"new_password" binding:"required"`
@@ -261,7 +597,7 @@ func UpdateAdminPassword(c *gin.Context) {
 func AdminAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token, err := c.Cookie("admin_token")
-		if err != nil || token == "" {
+		if err != nil || !validAdminSession(token) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
@@ -271,17 +607,9 @@ func AdminAuthMiddleware() gin.HandlerFunc {
 }
 
 func getClientIP(c *gin.Context) string {
-	ip := c.GetHeader("X-Real-IP")
-	if ip == "" {
-		ip = c.GetHeader("X-Forwarded-For")
-		if ip != "" {
-			ips := strings.Split(ip, ",")
-			ip = strings.TrimSpace(ips[0])
-		}
-	}
-	if ip == "" {
-		ip = c.ClientIP()
-	}
+	// 只有来自 trusted_proxies 的请求才采用 X-Forwarded-For / X-Real-IP，
+	// 否则为连接的对端地址，客户端无法伪造
+	ip := c.ClientIP()
 
 	// 过滤掉无效IP
 	if ip == "" || ip == "0.0.0.0" || ip == "::1" || strings.HasPrefix(ip, "::") {
@@ -292,6 +620,17 @@ func getClientIP(c *gin.Context) string {
 	return ip
 }
 
-func generateToken() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 36)
+// formatDuration 将授权时长格式化为 "24 hours"、"30 minutes" 形式
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	minutes := int(d / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
 }
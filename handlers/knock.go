@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultKnockLength 为轮换时生成的敲门序列长度
+const defaultKnockLength = 3
+
+func GetKnockSequence(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get knock sequence"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sequence":             config.KnockSequence,
+		"step_timeout_seconds": int(iptables.KnockStepTimeout.Seconds()),
+	})
+}
+
+// UpdateKnockSequence 设置敲门序列，sequence 为空时关闭敲门
+func UpdateKnockSequence(c *gin.Context) {
+	var req struct {
+		Sequence string `json:"sequence"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	sequence := ""
+	var steps []iptables.PortRule
+	if req.Sequence != "" {
+		var err error
+		steps, err = iptables.ParseKnockSequence(req.Sequence)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		sequence = iptables.FormatPorts(steps)
+	}
+
+	applyKnockSequence(c, sequence, steps)
+}
+
+// RotateKnockSequence 生成新的随机敲门序列替换当前序列，length 默认3
+func RotateKnockSequence(c *gin.Context) {
+	var req struct {
+		Length int `json:"length"`
+	}
+	// 请求体可以为空
+	c.ShouldBindJSON(&req)
+	if req.Length == 0 {
+		req.Length = defaultKnockLength
+	}
+
+	sequence, err := iptables.RandomKnockSequence(req.Length)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	steps, _ := iptables.ParseKnockSequence(sequence)
+
+	applyKnockSequence(c, sequence, steps)
+}
+
+func applyKnockSequence(c *gin.Context, sequence string, steps []iptables.PortRule) {
+	if err := database.UpdateKnockSequence(sequence); err != nil {
+		log.Printf("Error updating knock sequence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update knock sequence"})
+		return
+	}
+
+	if err := iptables.ApplyKnockSequence(steps); err != nil {
+		log.Printf("Error applying knock sequence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update firewall"})
+		return
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	if sequence == "" {
+		log.Printf("Port knocking disabled by admin")
+	} else {
+		log.Printf("Knock sequence changed by admin")
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Knock sequence updated successfully", "sequence": sequence})
+}
@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// linkErrorStatus 将链接不可用的原因转换为HTTP状态码和提示
+func linkErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, access.ErrLinkInvalid):
+		return http.StatusNotFound, "Invalid link"
+	case errors.Is(err, access.ErrLinkRevoked):
+		return http.StatusGone, "This link has been revoked"
+	case errors.Is(err, access.ErrLinkExpired):
+		return http.StatusGone, "This link has expired"
+	case errors.Is(err, access.ErrLinkUsedUp):
+		return http.StatusGone, "This link has no remaining uses"
+	case errors.Is(err, access.ErrLinkNotAllowed):
+		return http.StatusBadRequest, "Service not allowed by this link"
+	}
+	return http.StatusInternalServerError, "Internal server error"
+}
+
+// linkURL 按当前请求的地址拼接访问链接
+func linkURL(c *gin.Context, token string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/link/%s", scheme, c.Request.Host, token)
+}
+
+// recordLinkUse 记录一次链接使用，供审计
+func recordLinkUse(linkID int, ip string, serviceID int, success bool, detail string) {
+	err := database.AddAccessLinkUse(models.AccessLinkUse{
+		LinkID:    linkID,
+		IP:        ip,
+		ServiceID: serviceID,
+		Country:   geoip.Country(ip),
+		Success:   success,
+		Detail:    detail,
+	})
+	if err != nil {
+		log.Printf("Error recording access link use: %v", err)
+	}
+}
+
+func AccessLinkPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "access_link.html", nil)
+}
+
+// GetAccessLinkInfo 供链接页面显示可解锁的服务和剩余次数，不占用使用次数。
+// 链接预览、邮件扫描等只会打开页面，真正解锁需要访问者点击按钮
+func GetAccessLinkInfo(c *gin.Context) {
+	link, err := access.LookupLink(c.Param("token"))
+	if err == nil {
+		err = access.CheckLink(link, time.Now())
+	}
+	if err != nil {
+		status, message := linkErrorStatus(err)
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	services := make([]gin.H, 0, len(link.ServiceIDs))
+	for _, id := range link.ServiceIDs {
+		policy, err := access.ResolvePolicy(id)
+		if err != nil {
+			continue
+		}
+		services = append(services, gin.H{"id": id, "name": policy.ServiceName, "ports": policy.Ports})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"description":    link.Description,
+		"services":       services,
+		"remaining_uses": link.MaxUses - link.Uses,
+		"expires_at":     link.ExpiresAt.Format(time.RFC3339),
+		"duration":       formatDuration(time.Duration(link.DurationMinutes) * time.Minute),
+	})
+}
+
+// RedeemAccessLink 使用链接解锁访问者的IP，除了不校验密码外与 UserLogin 的检查相同，
+// 每次尝试都记录到链接的使用记录中
+func RedeemAccessLink(c *gin.Context) {
+	clientIP := getClientIP(c)
+	if clientIP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to determine client IP"})
+		return
+	}
+
+	var req struct {
+		ServiceID int `json:"service_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	link, err := access.LookupLink(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, access.ErrLinkInvalid) {
+			// 伪造的链接与错误的密码一样计入登录失败，已被锁定时不再记录
+			if policy, err := access.ResolvePolicy(0); err == nil {
+				if policy.IsLockedOut(clientIP) {
+					c.JSON(http.StatusTooManyRequests, gin.H{
+						"error": "Too many failed attempts. Please try again later.",
+					})
+					return
+				}
+				policy.RecordAttempt(clientIP, access.MethodLink, false)
+			}
+		} else {
+			log.Printf("Error looking up access link: %v", err)
+		}
+		status, message := linkErrorStatus(err)
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	reject := func(err error) {
+		recordLinkUse(link.ID, clientIP, req.ServiceID, false, err.Error())
+		status, message := linkErrorStatus(err)
+		c.JSON(status, gin.H{"error": message})
+	}
+
+	if err := access.CheckLink(link, time.Now()); err != nil {
+		reject(err)
+		return
+	}
+
+	policy, err := access.LinkPolicy(link, req.ServiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			reject(errors.New("service no longer exists"))
+			return
+		}
+		if !errors.Is(err, access.ErrLinkNotAllowed) {
+			log.Printf("Error getting login policy: %v", err)
+		}
+		reject(err)
+		return
+	}
+
+	if country, err := access.CheckCountry(clientIP); err != nil {
+		if errors.Is(err, access.ErrCountryDenied) {
+			log.Printf("Rejected access link %d from %s (country %q)", link.ID, clientIP, country)
+			recordLinkUse(link.ID, clientIP, req.ServiceID, false, "country not allowed")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access from your country is not allowed"})
+			return
+		}
+		log.Printf("Error checking country policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if policy.IsLockedOut(clientIP) {
+		recordLinkUse(link.ID, clientIP, req.ServiceID, false, "locked out")
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many failed attempts. Please try again later.",
+		})
+		return
+	}
+
+	// 先占用次数再解锁，并发打开同一链接也不会超出次数
+	ok, err := database.ConsumeAccessLinkUse(link.ID, time.Now())
+	if err != nil {
+		log.Printf("Error consuming access link use: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !ok {
+		reject(access.ErrLinkUsedUp)
+		return
+	}
+
+	description := fmt.Sprintf("Access link #%d", link.ID)
+	if link.Description != "" {
+		description += ": " + link.Description
+	}
+	if policy.ServiceName != "" {
+		description += " (" + policy.ServiceName + ")"
+	}
+
+	expiresAt, err := access.Grant(clientIP, access.MethodLink, policy, description)
+	if err != nil {
+		if err := database.ReleaseAccessLinkUse(link.ID); err != nil {
+			log.Printf("Error releasing access link use: %v", err)
+		}
+		if errors.Is(err, access.ErrCountryDenied) {
+			recordLinkUse(link.ID, clientIP, req.ServiceID, false, "country not allowed")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access from your country is not allowed"})
+			return
+		}
+		log.Printf("Error whitelisting IP %s: %v", clientIP, err)
+		recordLinkUse(link.ID, clientIP, req.ServiceID, false, "failed to whitelist IP")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to whitelist IP"})
+		return
+	}
+
+	policy.RecordAttempt(clientIP, access.MethodLink, true)
+	recordLinkUse(link.ID, clientIP, req.ServiceID, true, "granted until "+expiresAt.Format(time.RFC3339))
+	log.Printf("IP %s whitelisted by access link %d until %s", clientIP, link.ID, expiresAt.Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Access granted. Your IP has been whitelisted for %s.", formatDuration(policy.GrantDuration)),
+		"ip":      clientIP,
+		"expires": expiresAt.Format(time.RFC3339),
+		"ports":   policy.Ports,
+		"service": policy.ServiceName,
+	})
+}
+
+func GetAccessLinks(c *gin.Context) {
+	links, err := database.GetAllAccessLinks()
+	if err != nil {
+		log.Printf("Error getting access links: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get access links"})
+		return
+	}
+	if links == nil {
+		links = []models.AccessLink{}
+	}
+
+	for i := range links {
+		token, err := access.SignLink(links[i].ID)
+		if err != nil {
+			log.Printf("Error signing access link: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get access links"})
+			return
+		}
+		links[i].URL = linkURL(c, token)
+	}
+	c.JSON(http.StatusOK, links)
+}
+
+// CreateAccessLink 生成新的访问链接，返回可以直接发给对方的URL
+func CreateAccessLink(c *gin.Context) {
+	var req struct {
+		Description string `json:"description"`
+		// ServiceIDs 为允许解锁的服务，0 表示默认登录，留空时只允许默认登录
+		ServiceIDs      []int `json:"service_ids"`
+		MaxUses         int   `json:"max_uses"`
+		DurationMinutes int   `json:"duration_minutes" binding:"required"`
+		// ExpiresAt 为RFC3339格式，链接在此之后不能再使用
+		ExpiresAt string `json:"expires_at" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiry time"})
+		return
+	}
+	if !expiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry time must be in the future"})
+		return
+	}
+	if req.DurationMinutes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Duration must be positive"})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Max uses must be positive"})
+		return
+	}
+
+	if len(req.ServiceIDs) == 0 {
+		req.ServiceIDs = []int{0}
+	}
+	seen := make(map[int]bool)
+	var serviceIDs []int
+	for _, id := range req.ServiceIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if id != 0 {
+			if _, err := database.GetService(id); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service"})
+				return
+			}
+		}
+		serviceIDs = append(serviceIDs, id)
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	maxDuration, err := access.MaxLinkDuration(serviceIDs, config)
+	if err != nil {
+		log.Printf("Error resolving link policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if time.Duration(req.DurationMinutes)*time.Minute > maxDuration {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Duration must be at most %s for the selected services", formatDuration(maxDuration)),
+		})
+		return
+	}
+
+	id, err := database.AddAccessLink(models.AccessLink{
+		Description:     strings.TrimSpace(req.Description),
+		ServiceIDs:      serviceIDs,
+		MaxUses:         req.MaxUses,
+		DurationMinutes: req.DurationMinutes,
+		ExpiresAt:       expiresAt,
+	})
+	if err != nil {
+		log.Printf("Error adding access link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access link"})
+		return
+	}
+
+	token, err := access.SignLink(id)
+	if err != nil {
+		log.Printf("Error signing access link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create access link"})
+		return
+	}
+
+	log.Printf("Access link %d created by admin (max %d uses, expires %s)", id, req.MaxUses, expiresAt.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{"message": "Access link created successfully", "id": id, "url": linkURL(c, token)})
+}
+
+// RevokeAccessLink 使链接立即失效，已通过链接解锁的白名单条目保留到过期
+func RevokeAccessLink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetAccessLink(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Access link not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get access link"})
+		return
+	}
+
+	if err := database.RevokeAccessLink(id); err != nil {
+		log.Printf("Error revoking access link: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Access link revoked successfully"})
+}
+
+// GetAccessLinkUses 返回链接的使用记录，包括被拒绝的尝试
+func GetAccessLinkUses(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	uses, err := database.GetAccessLinkUses(id)
+	if err != nil {
+		log.Printf("Error getting access link uses: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get access link uses"})
+		return
+	}
+	if uses == nil {
+		uses = []models.AccessLinkUse{}
+	}
+	c.JSON(http.StatusOK, uses)
+}
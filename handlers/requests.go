@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/models"
+	"iptables-safe/notify"
+
+	"github.com/gin-gonic/gin"
+)
+
+// submitRequestMu 串行化申请的数量检查和写入
+var submitRequestMu sync.Mutex
+
+// SubmitAccessRequest 由登录页提交访问申请，返回申请人查询结果使用的令牌。
+// 与密码登录一样先检查国家和锁定
+func SubmitAccessRequest(c *gin.Context) {
+	clientIP := getClientIP(c)
+	if clientIP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to determine client IP"})
+		return
+	}
+
+	var req struct {
+		Name            string `json:"name" binding:"required"`
+		Reason          string `json:"reason"`
+		DurationMinutes int    `json:"duration_minutes"`
+		ServiceID       int    `json:"service_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	reason := strings.TrimSpace(req.Reason)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be 1-64 characters"})
+		return
+	}
+	if utf8.RuneCountInString(reason) > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason must be at most 500 characters"})
+		return
+	}
+
+	policy, err := access.ResolvePolicy(req.ServiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service"})
+			return
+		}
+		log.Printf("Error getting login policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	maxMinutes := int(policy.GrantDuration / time.Minute)
+	if req.DurationMinutes == 0 {
+		req.DurationMinutes = maxMinutes
+	}
+	if req.DurationMinutes < 0 || req.DurationMinutes > maxMinutes {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Requested duration must be at most %s", formatDuration(policy.GrantDuration)),
+		})
+		return
+	}
+
+	country, err := access.CheckCountry(clientIP)
+	if err != nil {
+		if errors.Is(err, access.ErrCountryDenied) {
+			log.Printf("Rejected access request from %s (country %q)", clientIP, country)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access from your country is not allowed"})
+			return
+		}
+		log.Printf("Error checking country policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if policy.IsLockedOut(clientIP) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "Too many failed attempts. Please try again later.",
+		})
+		return
+	}
+
+	// 计数和写入在同一把锁内完成，并发提交不会超过上限
+	submitRequestMu.Lock()
+	defer submitRequestMu.Unlock()
+
+	pending, err := database.CountPendingAccessRequests(clientIP)
+	if err != nil {
+		log.Printf("Error counting pending access requests: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if pending >= access.MaxPendingRequests {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many pending requests. Please wait for a decision."})
+		return
+	}
+	total, err := database.CountAllPendingAccessRequests()
+	if err != nil {
+		log.Printf("Error counting pending access requests: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if total >= access.MaxTotalPendingRequests {
+		log.Printf("Rejected access request from %s: %d requests already pending", clientIP, total)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many pending requests. Please try again later."})
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating access request token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	token := hex.EncodeToString(b)
+
+	request := models.AccessRequest{
+		Name:            name,
+		Reason:          reason,
+		IP:              clientIP,
+		Country:         country,
+		ServiceID:       policy.ServiceID,
+		ServiceName:     policy.ServiceName,
+		DurationMinutes: req.DurationMinutes,
+		Token:           token,
+	}
+	id, err := database.AddAccessRequest(request)
+	if err != nil {
+		log.Printf("Error adding access request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit request"})
+		return
+	}
+	request.ID = id
+
+	log.Printf("Access request %d submitted by %s from %s", id, name, clientIP)
+	notify.Send("access_request.created",
+		fmt.Sprintf("New access request #%d from %s (%s): %s", id, name, clientIP, reason), request)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request submitted. Please wait for an administrator.", "id": id, "token": token})
+}
+
+// GetAccessRequestStatus 供申请人轮询申请结果
+func GetAccessRequestStatus(c *gin.Context) {
+	request, err := database.GetAccessRequestByToken(c.Param("token"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
+			return
+		}
+		log.Printf("Error getting access request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	result := gin.H{
+		"id":         request.ID,
+		"status":     request.Status,
+		"note":       request.Note,
+		"ip":         request.IP,
+		"service":    request.ServiceName,
+		"created_at": request.CreatedAt.Format(time.RFC3339),
+	}
+	if request.ExpiresAt != nil {
+		result["expires"] = request.ExpiresAt.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetAccessRequests 返回待处理的申请和最近7天内的其他申请
+func GetAccessRequests(c *gin.Context) {
+	requests, err := database.GetAccessRequests(time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		log.Printf("Error getting access requests: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get access requests"})
+		return
+	}
+	if requests == nil {
+		requests = []models.AccessRequest{}
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+// getPendingRequest 读取路径中的申请，申请不存在或已处理时直接写入错误响应并返回nil
+func getPendingRequest(c *gin.Context) *models.AccessRequest {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return nil
+	}
+
+	request, err := database.GetAccessRequest(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Request not found"})
+			return nil
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get request"})
+		return nil
+	}
+	if request.Status != access.RequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Request has already been decided"})
+		return nil
+	}
+	return request
+}
+
+// ApproveAccessRequest 批准申请并解锁申请时的IP，duration_minutes 可以缩短申请的时长
+func ApproveAccessRequest(c *gin.Context) {
+	var req struct {
+		DurationMinutes int    `json:"duration_minutes"`
+		Note            string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	request := getPendingRequest(c)
+	if request == nil {
+		return
+	}
+
+	duration := request.DurationMinutes
+	if req.DurationMinutes != 0 {
+		if req.DurationMinutes < 0 || req.DurationMinutes > request.DurationMinutes {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duration must not exceed the requested duration"})
+			return
+		}
+		duration = req.DurationMinutes
+	}
+
+	policy, err := access.ResolvePolicy(request.ServiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Service no longer exists"})
+			return
+		}
+		log.Printf("Error getting login policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	policy.GrantDuration = time.Duration(duration) * time.Minute
+
+	note := strings.TrimSpace(req.Note)
+	ok, err := database.DecideAccessRequest(request.ID, access.RequestApproved, note, duration)
+	if err != nil {
+		log.Printf("Error approving access request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve request"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Request has already been decided"})
+		return
+	}
+
+	description := fmt.Sprintf("Access request #%d: %s", request.ID, request.Name)
+	if policy.ServiceName != "" {
+		description += " (" + policy.ServiceName + ")"
+	}
+	expiresAt, err := access.Grant(request.IP, access.MethodRequest, policy, description)
+	if err != nil {
+		if err := database.ReopenAccessRequest(request.ID, request.DurationMinutes); err != nil {
+			log.Printf("Error reopening access request: %v", err)
+		}
+		if errors.Is(err, access.ErrCountryDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Requester's country is not allowed"})
+			return
+		}
+		log.Printf("Error whitelisting IP %s: %v", request.IP, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to whitelist IP"})
+		return
+	}
+
+	if err := database.SetAccessRequestExpiry(request.ID, expiresAt); err != nil {
+		log.Printf("Error updating access request: %v", err)
+	}
+
+	log.Printf("Access request %d approved, IP %s whitelisted until %s", request.ID, request.IP, expiresAt.Format(time.RFC3339))
+	notify.Send("access_request.approved",
+		fmt.Sprintf("Access request #%d from %s (%s) approved until %s", request.ID, request.Name, request.IP,
+			expiresAt.Format(time.RFC3339)), request)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request approved", "expires": expiresAt.Format(time.RFC3339)})
+}
+
+func DenyAccessRequest(c *gin.Context) {
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	request := getPendingRequest(c)
+	if request == nil {
+		return
+	}
+
+	ok, err := database.DecideAccessRequest(request.ID, access.RequestDenied, strings.TrimSpace(req.Note), request.DurationMinutes)
+	if err != nil {
+		log.Printf("Error denying access request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deny request"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "Request has already been decided"})
+		return
+	}
+
+	log.Printf("Access request %d from %s denied", request.ID, request.IP)
+	notify.Send("access_request.denied",
+		fmt.Sprintf("Access request #%d from %s (%s) denied", request.ID, request.Name, request.IP), request)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Request denied"})
+}
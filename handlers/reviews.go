@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetReviews 返回需要复核的永久条目
+func GetReviews(c *gin.Context) {
+	items, err := access.PendingReviews()
+	if err != nil {
+		log.Printf("Error getting pending reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reviews"})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// ReviewWhitelistIP 对待复核条目做出决定：recertify 保留并重新计时，remove 删除条目。
+// 每个决定都写入复核记录和条目历史
+func ReviewWhitelistIP(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		Decision string `json:"decision" binding:"required"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Decision != "recertify" && req.Decision != "remove" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Decision must be recertify or remove"})
+		return
+	}
+
+	entry, err := database.GetWhitelistIP(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get IP"})
+		return
+	}
+	if !entry.IsPermanent {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only permanent entries are reviewed"})
+		return
+	}
+
+	config, err := database.GetConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	reasons := access.ReviewReasons(*entry, config, time.Now())
+
+	note := strings.TrimSpace(req.Note)
+	if req.Decision == "recertify" {
+		err = database.MarkWhitelistReviewed(id, time.Now())
+	} else {
+		err = removeWhitelistEntry(id)
+	}
+	if err != nil {
+		log.Printf("Error applying review decision: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply decision"})
+		return
+	}
+
+	decision := models.ReviewDecision{
+		EntryID:     id,
+		IP:          entry.IP,
+		Description: entry.Description,
+		Decision:    req.Decision,
+		Reasons:     strings.Join(reasons, ","),
+		Note:        note,
+		AdminIP:     getClientIP(c),
+	}
+	if err := database.AddReviewDecision(decision); err != nil {
+		log.Printf("Error recording review decision: %v", err)
+	}
+	detail := "review: " + req.Decision
+	if note != "" {
+		detail += " (" + note + ")"
+	}
+	database.AddWhitelistHistory(id, "reviewed", detail)
+	log.Printf("Whitelist entry %d (%s) review decision: %s", id, entry.IP, req.Decision)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Review decision recorded"})
+}
+
+// GetReviewDecisions 返回最近的复核决定
+func GetReviewDecisions(c *gin.Context) {
+	decisions, err := database.GetReviewDecisions(200)
+	if err != nil {
+		log.Printf("Error getting review decisions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get review decisions"})
+		return
+	}
+	if decisions == nil {
+		decisions = []models.ReviewDecision{}
+	}
+	c.JSON(http.StatusOK, decisions)
+}
+
+func GetReviewPolicy(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"stale_after_days":     config.StaleAfterDays,
+		"review_interval_days": config.ReviewIntervalDays,
+	})
+}
+
+// UpdateReviewPolicy 设置永久条目的复核周期，0表示不检查对应项
+func UpdateReviewPolicy(c *gin.Context) {
+	var req struct {
+		StaleAfterDays     int `json:"stale_after_days"`
+		ReviewIntervalDays int `json:"review_interval_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.StaleAfterDays < 0 || req.ReviewIntervalDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Days must not be negative"})
+		return
+	}
+
+	if err := database.UpdateReviewPolicy(req.StaleAfterDays, req.ReviewIntervalDays); err != nil {
+		log.Printf("Error updating review policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Review policy updated successfully"})
+}
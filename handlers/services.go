@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/iptables"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type serviceRequest struct {
+	Name               string `json:"name" binding:"required"`
+	Ports              string `json:"ports"`
+	Password           string `json:"password"`
+	MaxDurationMinutes int    `json:"max_duration_minutes"`
+	MaxFailedAttempts  int    `json:"max_failed_attempts"`
+	LockoutMinutes     int    `json:"lockout_minutes"`
+}
+
+// toService 校验请求并填充默认值
+func (r *serviceRequest) toService() (models.Service, error) {
+	ports, err := iptables.NormalizePorts(r.Ports)
+	if err != nil {
+		return models.Service{}, err
+	}
+
+	s := models.Service{
+		Name:               strings.TrimSpace(r.Name),
+		Ports:              ports,
+		MaxDurationMinutes: r.MaxDurationMinutes,
+		MaxFailedAttempts:  r.MaxFailedAttempts,
+		LockoutMinutes:     r.LockoutMinutes,
+	}
+	if s.Name == "" {
+		return s, errors.New("service name is required")
+	}
+	// 端口为空的规则会放行全部端口，服务必须限定端口
+	if s.Ports == "" {
+		return s, errors.New("service ports are required")
+	}
+	if s.MaxDurationMinutes <= 0 {
+		s.MaxDurationMinutes = int(access.DefaultGrantDuration / time.Minute)
+	}
+	if s.MaxFailedAttempts <= 0 {
+		s.MaxFailedAttempts = access.DefaultMaxFailedAttempts
+	}
+	if s.LockoutMinutes <= 0 {
+		s.LockoutMinutes = int(access.DefaultLockoutDuration / time.Minute)
+	}
+	return s, nil
+}
+
+// GetPublicServices 供登录页选择要解锁的服务，只返回名称和端口
+func GetPublicServices(c *gin.Context) {
+	services, err := database.GetAllServices()
+	if err != nil {
+		log.Printf("Error getting services: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get services"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(services))
+	for _, s := range services {
+		result = append(result, gin.H{
+			"id": s.ID, "name": s.Name, "ports": s.Ports, "max_duration_minutes": s.MaxDurationMinutes,
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func GetServices(c *gin.Context) {
+	services, err := database.GetAllServices()
+	if err != nil {
+		log.Printf("Error getting services: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get services"})
+		return
+	}
+	if services == nil {
+		services = []models.Service{}
+	}
+	c.JSON(http.StatusOK, services)
+}
+
+func AddService(c *gin.Context) {
+	var req serviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	service, err := req.toService()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+
+	id, err := database.AddService(service, req.Password)
+	if err != nil {
+		log.Printf("Error adding service: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add service"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service added successfully", "id": id})
+}
+
+// UpdateService 修改服务配置；端口变化会同步到该服务已解锁的条目
+func UpdateService(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req serviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	service, err := req.toService()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	service.ID = id
+
+	existing, err := database.GetService(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service"})
+		return
+	}
+
+	if err := database.UpdateService(service, req.Password); err != nil {
+		log.Printf("Error updating service: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service"})
+		return
+	}
+
+	if existing.Ports != service.Ports {
+		applyServicePorts(id, service.Ports)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service updated successfully"})
+}
+
+func applyServicePorts(serviceID int, ports string) {
+	entries, err := database.GetAllWhitelistIPs()
+	if err != nil {
+		log.Printf("Error getting whitelist IPs: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.ServiceID != serviceID {
+			continue
+		}
+		if err := database.UpdateWhitelistIP(entry.ID, entry.Description, ports); err != nil {
+			log.Printf("Error updating IP in database: %v", err)
+			continue
+		}
+		if err := iptables.AddIPToWhitelist(entry.ID, entry.IP, ports); err != nil {
+			log.Printf("Error updating IP in iptables: %v", err)
+		}
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+}
+
+func DeleteService(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	entryIDs, err := database.DeleteService(id)
+	if err != nil {
+		log.Printf("Error deleting service: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service"})
+		return
+	}
+
+	for _, entryID := range entryIDs {
+		if err := iptables.RemoveIPFromWhitelist(entryID); err != nil {
+			log.Printf("Error removing IP from iptables: %v", err)
+		}
+	}
+
+	if err := iptables.SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service deleted successfully"})
+}
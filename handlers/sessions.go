@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// adminSessionTTL 与 admin_token Cookie 的有效期一致
+const adminSessionTTL = time.Hour
+
+// adminSessions 保存由服务端签发的管理员会话（令牌的哈希 -> 过期时间），
+// 只有登录时签发的令牌才能通过 AdminAuthMiddleware，重启后需要重新登录
+var (
+	adminSessionsMu sync.Mutex
+	adminSessions   = make(map[string]time.Time)
+)
+
+// newAdminSession 生成随机会话令牌并登记，返回写入Cookie的令牌
+func newAdminSession() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	adminSessionsMu.Lock()
+	defer adminSessionsMu.Unlock()
+	now := time.Now()
+	for hash, expires := range adminSessions {
+		if now.After(expires) {
+			delete(adminSessions, hash)
+		}
+	}
+	adminSessions[hashToken(token)] = now.Add(adminSessionTTL)
+	return token, nil
+}
+
+// validAdminSession 检查令牌是否为未过期的已签发会话
+func validAdminSession(token string) bool {
+	if token == "" {
+		return false
+	}
+	adminSessionsMu.Lock()
+	defer adminSessionsMu.Unlock()
+	hash := hashToken(token)
+	expires, ok := adminSessions[hash]
+	if ok && time.Now().After(expires) {
+		delete(adminSessions, hash)
+		return false
+	}
+	return ok
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
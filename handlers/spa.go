@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"iptables-safe/database"
+	"iptables-safe/spa"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSPASettings 返回单包授权的端口和密钥，port 为0表示 firewall.json 中未启用
+func GetSPASettings(c *gin.Context) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get SPA settings"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"port": spa.Port(), "key": config.SPAKey})
+}
+
+// RotateSPAKey 生成新的SPA密钥，旧密钥立即失效
+func RotateSPAKey(c *gin.Context) {
+	key, err := spa.GenerateKey()
+	if err != nil {
+		log.Printf("Error generating SPA key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate key"})
+		return
+	}
+
+	if err := database.UpdateSPAKey(key); err != nil {
+		log.Printf("Error updating SPA key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update SPA key"})
+		return
+	}
+
+	log.Printf("SPA key rotated by admin")
+	c.JSON(http.StatusOK, gin.H{"message": "SPA key rotated successfully", "key": key})
+}
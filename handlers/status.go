@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+	"iptables-safe/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusEntry 是状态页显示的一个白名单条目，不包含管理员填写的描述
+type statusEntry struct {
+	ID          int        `json:"id"`
+	ServiceID   int        `json:"service_id"`
+	ServiceName string     `json:"service_name"`
+	Ports       string     `json:"ports"`
+	IsPermanent bool       `json:"is_permanent"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	// Remaining 为距过期的秒数，永久条目为0
+	Remaining int64 `json:"remaining_seconds"`
+	// Manageable 表示用户可以自行撤销，永久条目和域名条目只能由管理员管理
+	Manageable bool `json:"manageable"`
+	// Extendable 表示用户可以自行延长：授权凭用户自己的凭据获得，且当前浏览器持有网页登录时发放的延长令牌
+	Extendable bool `json:"extendable"`
+	// MaxDurationMinutes 为延长后距现在的最长时间
+	MaxDurationMinutes int `json:"max_duration_minutes"`
+}
+
+// userIPEntry 是同一用户在其他IP上的授权，只显示地址、服务和过期时间
+type userIPEntry struct {
+	UserName    string    `json:"user_name"`
+	IP          string    `json:"ip"`
+	ServiceName string    `json:"service_name"`
+	Ports       string    `json:"ports"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// extendTokenCookie 为网页登录时发放给浏览器的延长令牌。延长授权必须出示该令牌，
+// 与授权地址处于同一出口的其他人无法替用户延长
+const extendTokenCookie = "extend_token"
+
+// issueExtendToken 把浏览器的延长令牌关联到 ip 对该服务的条目，浏览器还没有令牌时生成一个
+func issueExtendToken(c *gin.Context, ip string, serviceID int) {
+	token, _ := c.Cookie(extendTokenCookie)
+	if len(token) != 64 {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("Error generating extend token: %v", err)
+			return
+		}
+		token = hex.EncodeToString(b)
+	}
+
+	entries, err := database.GetActiveWhitelistIPsByAddress(ip)
+	if err != nil {
+		log.Printf("Error getting whitelist entries of %s: %v", ip, err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.ServiceID != serviceID || !userManageable(entry) {
+			continue
+		}
+		if err := database.SetWhitelistExtendToken(entry.ID, hashExtendToken(token)); err != nil {
+			log.Printf("Error saving extend token of whitelist entry %d: %v", entry.ID, err)
+			return
+		}
+		c.SetSameSite(http.SameSiteStrictMode)
+		c.SetCookie(extendTokenCookie, token, 30*24*3600, "/", "", false, true)
+	}
+}
+
+func hashExtendToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasExtendToken 判断当前浏览器是否持有条目的延长令牌
+func hasExtendToken(c *gin.Context, entryID int) bool {
+	token, _ := c.Cookie(extendTokenCookie)
+	if token == "" {
+		return false
+	}
+	stored, err := database.GetWhitelistExtendToken(entryID)
+	if err != nil || stored == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(hashExtendToken(token))) == 1
+}
+
+func UserStatusPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "status.html", nil)
+}
+
+// GetUserStatus 返回当前IP的全部有效白名单条目。当前IP有通过SSH或DNS解锁的条目时，
+// 同时返回这些用户在其他IP上的授权
+func GetUserStatus(c *gin.Context) {
+	clientIP := getClientIP(c)
+	if clientIP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to determine client IP"})
+		return
+	}
+
+	entries, err := database.GetActiveWhitelistIPsByAddress(clientIP)
+	if err != nil {
+		log.Printf("Error getting whitelist entries of %s: %v", clientIP, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	now := time.Now()
+	result := []statusEntry{}
+	userIPs := []userIPEntry{}
+	seenUsers := make(map[int]bool)
+	for _, entry := range entries {
+		if entry.UserID != 0 && !seenUsers[entry.UserID] {
+			seenUsers[entry.UserID] = true
+			userIPs = append(userIPs, otherUserIPs(entry.UserID, clientIP)...)
+		}
+
+		item := statusEntry{
+			ID:          entry.ID,
+			ServiceID:   entry.ServiceID,
+			ServiceName: entry.ServiceName,
+			Ports:       entry.Ports,
+			IsPermanent: entry.IsPermanent,
+		}
+		if !entry.IsPermanent {
+			expiresAt := entry.ExpiresAt
+			item.ExpiresAt = &expiresAt
+			item.Remaining = int64(expiresAt.Sub(now) / time.Second)
+		}
+		if userManageable(entry) {
+			item.Manageable = true
+			if access.SelfExtendable(&entry) && hasExtendToken(c, entry.ID) {
+				if policy, err := access.ResolvePolicy(entry.ServiceID); err == nil {
+					item.Extendable = true
+					item.MaxDurationMinutes = int(policy.GrantDuration / time.Minute)
+				}
+			}
+		}
+		result = append(result, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ip":       clientIP,
+		"country":  geoip.Country(clientIP),
+		"entries":  result,
+		"user_ips": userIPs,
+	})
+}
+
+// otherUserIPs 返回用户在 clientIP 以外的有效授权，出错时只记录日志
+func otherUserIPs(userID int, clientIP string) []userIPEntry {
+	entries, err := database.GetActiveUserWhitelistIPs(userID)
+	if err != nil {
+		log.Printf("Error getting whitelist entries of user %d: %v", userID, err)
+		return nil
+	}
+	var result []userIPEntry
+	for _, entry := range entries {
+		if entry.IP == clientIP {
+			continue
+		}
+		result = append(result, userIPEntry{
+			UserName:    entry.UserName,
+			IP:          entry.IP,
+			ServiceName: entry.ServiceName,
+			Ports:       entry.Ports,
+			ExpiresAt:   entry.ExpiresAt,
+		})
+	}
+	return result
+}
+
+// userManageable 返回用户能否自行延长或撤销该条目
+func userManageable(entry models.WhitelistIP) bool {
+	return !entry.IsPermanent && entry.Hostname == ""
+}
+
+// getOwnEntry 读取路径中属于当前IP且用户可以管理的条目，否则直接写入错误响应并返回nil。
+// 其他IP的条目一律按不存在处理
+func getOwnEntry(c *gin.Context) *models.WhitelistIP {
+	clientIP := getClientIP(c)
+	if clientIP == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to determine client IP"})
+		return nil
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return nil
+	}
+
+	entry, err := database.GetWhitelistIP(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+			return nil
+		}
+		log.Printf("Error getting whitelist entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil
+	}
+	if entry.IP != clientIP || (!entry.IsPermanent && !entry.ExpiresAt.After(time.Now())) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Entry not found"})
+		return nil
+	}
+	if !userManageable(*entry) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Entry is managed by an administrator"})
+		return nil
+	}
+	return entry
+}
+
+// ExtendUserEntry 把当前IP的临时条目延长到服务允许的最长时长
+func ExtendUserEntry(c *gin.Context) {
+	entry := getOwnEntry(c)
+	if entry == nil {
+		return
+	}
+	if !access.SelfExtendable(entry) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Duration of this entry was set by an administrator"})
+		return
+	}
+	if !hasExtendToken(c, entry.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the browser that unlocked this entry can extend it"})
+		return
+	}
+
+	policy, err := access.ResolvePolicy(entry.ServiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Service no longer exists"})
+			return
+		}
+		log.Printf("Error getting login policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	expiresAt, err := access.Extend(entry, policy)
+	if err != nil {
+		if errors.Is(err, access.ErrCountryDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access from your country is not allowed"})
+			return
+		}
+		if errors.Is(err, access.ErrLockedOut) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts. Please try again later."})
+			return
+		}
+		log.Printf("Error extending whitelist entry %d: %v", entry.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extend access"})
+		return
+	}
+
+	log.Printf("IP %s extended whitelist entry %d until %s", entry.IP, entry.ID, expiresAt.Format(time.RFC3339))
+	c.JSON(http.StatusOK, gin.H{"message": "Access extended", "expires": expiresAt.Format(time.RFC3339)})
+}
+
+// RevokeUserEntry 由用户提前撤销当前IP的临时条目
+func RevokeUserEntry(c *gin.Context) {
+	entry := getOwnEntry(c)
+	if entry == nil {
+		return
+	}
+
+	database.AddWhitelistHistory(entry.ID, "revoked", "by user")
+	if err := removeWhitelistEntry(entry.ID); err != nil {
+		log.Printf("Error deleting IP from database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+		return
+	}
+
+	log.Printf("IP %s revoked its whitelist entry %d", entry.IP, entry.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Access revoked"})
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"iptables-safe/database"
+	"iptables-safe/dnsknock"
+	"iptables-safe/models"
+	"iptables-safe/sshknock"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userNamePattern 限制用户名为常见的登录名字符
+var userNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func GetUsers(c *gin.Context) {
+	users, err := database.GetAllUsers()
+	if err != nil {
+		log.Printf("Error getting users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func AddUser(c *gin.Context) {
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if !userNamePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user name"})
+		return
+	}
+
+	id, err := database.AddUser(name)
+	if err != nil {
+		log.Printf("Error adding user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User added successfully", "id": id})
+}
+
+// DeleteUser 删除用户及其SSH公钥，已解锁的白名单条目保留到过期
+func DeleteUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetUser(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if err := database.DeleteUser(id); err != nil {
+		log.Printf("Error deleting user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// UpdateUserServices 设置用户可以通过SSH或DNS解锁的服务，0 表示默认登录，留空表示都不允许
+func UpdateUserServices(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		ServiceIDs []int `json:"service_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if _, err := database.GetUser(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	seen := make(map[int]bool)
+	serviceIDs := []int{}
+	for _, serviceID := range req.ServiceIDs {
+		if seen[serviceID] {
+			continue
+		}
+		seen[serviceID] = true
+		if serviceID != 0 {
+			if _, err := database.GetService(serviceID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service"})
+				return
+			}
+		}
+		serviceIDs = append(serviceIDs, serviceID)
+	}
+
+	if err := database.UpdateUserServices(id, serviceIDs); err != nil {
+		log.Printf("Error updating user services: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user services"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User services updated successfully"})
+}
+
+// AddSSHKey 为用户添加一把 authorized_keys 格式的公钥，不支持 authorized_keys 选项
+func AddSSHKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if _, err := database.GetUser(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	key, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(req.PublicKey)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid public key"})
+		return
+	}
+	if len(options) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key options are not supported"})
+		return
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	if _, err := database.GetSSHKeyByFingerprint(fingerprint); err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Key is already registered"})
+		return
+	}
+
+	keyID, err := database.AddSSHKey(models.SSHKey{
+		UserID:      id,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Fingerprint: fingerprint,
+		Comment:     comment,
+	})
+	if err != nil {
+		log.Printf("Error adding SSH key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Key added successfully", "id": keyID, "fingerprint": fingerprint})
+}
+
+func DeleteSSHKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetSSHKey(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get key"})
+		return
+	}
+
+	if err := database.DeleteSSHKey(id); err != nil {
+		log.Printf("Error deleting SSH key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Key deleted successfully"})
+}
+
+// RotateUserDNSSecret 为用户生成新的DNS解锁密钥，旧密钥立即失效。密钥只在此时返回一次
+func RotateUserDNSSecret(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	user, err := database.GetUser(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	secret, err := dnsknock.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating DNS knock secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := database.UpdateUserDNSSecret(id, secret); err != nil {
+		log.Printf("Error updating DNS knock secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update secret"})
+		return
+	}
+
+	log.Printf("DNS knock secret of user %s rotated by admin", user.Name)
+	c.JSON(http.StatusOK, gin.H{"message": "Secret rotated successfully", "secret": secret})
+}
+
+// DisableUserDNSSecret 清除用户的DNS解锁密钥
+func DisableUserDNSSecret(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if _, err := database.GetUser(id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	if err := database.UpdateUserDNSSecret(id, ""); err != nil {
+		log.Printf("Error clearing DNS knock secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "DNS knock disabled"})
+}
+
+// GetDNSKnockSettings 返回DNS解锁服务的端口和域名，port 为0表示未启用
+func GetDNSKnockSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"port": dnsknock.Port(),
+		"zone": dnsknock.Zone(),
+	})
+}
+
+// GetSSHKnockSettings 返回SSH解锁服务的端口和主机密钥指纹，port 为0表示未启用
+func GetSSHKnockSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"port":                 sshknock.Port(),
+		"host_key_fingerprint": sshknock.HostKeyFingerprint(),
+	})
+}
@@ -0,0 +1,132 @@
+package iptables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const conntrackProcFile = "/proc/net/nf_conntrack"
+
+// Connection 是conntrack中的一条连接，Source 为连接发起方。
+// 未开启 net.netfilter.nf_conntrack_acct 时包数和字节数为0
+type Connection struct {
+	Protocol        string `json:"protocol"`
+	State           string `json:"state"`
+	Source          string `json:"source"`
+	SourcePort      int    `json:"source_port"`
+	Destination     string `json:"destination"`
+	Port            int    `json:"port"`
+	PacketsSent     int64  `json:"packets_sent"`
+	BytesSent       int64  `json:"bytes_sent"`
+	PacketsReceived int64  `json:"packets_received"`
+	BytesReceived   int64  `json:"bytes_received"`
+	// Timeout 为连接跟踪条目剩余的秒数
+	Timeout int `json:"timeout"`
+}
+
+// ListConnections 读取 /proc/net/nf_conntrack，内核未提供该文件时改用 conntrack -L
+func ListConnections() ([]Connection, error) {
+	data, err := os.ReadFile(conntrackProcFile)
+	if err != nil {
+		output, cmdErr := exec.Command("conntrack", "-L", "-o", "extended").Output()
+		if cmdErr != nil {
+			return nil, fmt.Errorf("failed to read %s (%v) and conntrack -L failed: %v", conntrackProcFile, err, cmdErr)
+		}
+		data = output
+	}
+
+	var conns []Connection
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if conn, ok := parseConntrackLine(scanner.Text()); ok {
+			conns = append(conns, conn)
+		}
+	}
+	return conns, scanner.Err()
+}
+
+// parseConntrackLine 解析一行conntrack输出，例如：
+// ipv4 2 tcp 6 431999 ESTABLISHED src=1.2.3.4 dst=5.6.7.8 sport=51234 dport=22 packets=10 bytes=1000
+// src=5.6.7.8 dst=1.2.3.4 sport=22 dport=51234 packets=8 bytes=2000 [ASSURED] mark=0 use=2
+// 第一组 key=value 为发起方向，第二组为回复方向
+func parseConntrackLine(line string) (Connection, bool) {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && (fields[0] == "ipv4" || fields[0] == "ipv6") {
+		fields = fields[2:]
+	}
+	if len(fields) < 3 {
+		return Connection{}, false
+	}
+
+	conn := Connection{Protocol: fields[0]}
+	conn.Timeout, _ = strconv.Atoi(fields[2])
+
+	reply := false
+	for _, field := range fields[3:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			// TCP的状态在地址之前；UDP等无状态协议使用 [UNREPLIED]、[ASSURED] 标记
+			if conn.State == "" && (conn.Source == "" || strings.HasPrefix(field, "[")) {
+				conn.State = strings.Trim(field, "[]")
+			}
+			continue
+		}
+
+		switch key {
+		case "src":
+			if conn.Source != "" {
+				reply = true
+				continue
+			}
+			conn.Source = value
+		case "dst":
+			if !reply {
+				conn.Destination = value
+			}
+		case "sport":
+			if !reply {
+				conn.SourcePort, _ = strconv.Atoi(value)
+			}
+		case "dport":
+			if !reply {
+				conn.Port, _ = strconv.Atoi(value)
+			}
+		case "packets":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			if reply {
+				conn.PacketsReceived = n
+			} else {
+				conn.PacketsSent = n
+			}
+		case "bytes":
+			n, _ := strconv.ParseInt(value, 10, 64)
+			if reply {
+				conn.BytesReceived = n
+			} else {
+				conn.BytesSent = n
+			}
+		}
+	}
+	return conn, conn.Source != ""
+}
+
+// MatchesEntry 判断连接是否由白名单条目放行：来源为条目IP，且目的端口在条目端口内
+func (c Connection) MatchesEntry(ip string, portRules []PortRule) bool {
+	if c.Source != ip {
+		return false
+	}
+	if len(portRules) == 0 {
+		return true
+	}
+	for _, p := range portRules {
+		if p.Matches(c.Protocol, c.Port) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package iptables
+
+import "testing"
+
+func TestParseConntrackLine(t *testing.T) {
+	line := "ipv4 2 tcp 6 431999 ESTABLISHED src=1.2.3.4 dst=5.6.7.8 sport=51234 dport=22 packets=10 bytes=1000 " +
+		"src=5.6.7.8 dst=1.2.3.4 sport=22 dport=51234 packets=8 bytes=2000 [ASSURED] mark=0 use=2"
+	conn, ok := parseConntrackLine(line)
+	if !ok {
+		t.Fatal("failed to parse a complete line")
+	}
+	if conn.Protocol != "tcp" || conn.State != "ESTABLISHED" || conn.Source != "1.2.3.4" || conn.Timeout != 431999 {
+		t.Errorf("got %+v", conn)
+	}
+
+	// 被截断的行不能导致越界
+	for _, line := range []string{"", "ipv4", "ipv6 10", "ipv4 2 tcp"} {
+		if _, ok := parseConntrackLine(line); ok {
+			t.Errorf("%q: parsed a truncated line", line)
+		}
+	}
+}
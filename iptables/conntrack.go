@@ -0,0 +1,151 @@
+package iptables
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// flushOnRevoke 为true时，撤销白名单规则后同时删除被撤销地址的conntrack连接，
+// 否则已建立的连接会因ESTABLISHED,RELATED规则继续保持
+var flushOnRevoke bool
+
+var flowsDeletedPattern = regexp.MustCompile(`(\d+) flow entries have been deleted`)
+
+// ConnFilter 描述一组需要断开的连接：来源地址，以及可选的协议和目的端口
+type ConnFilter struct {
+	Source   string
+	Protocol string
+	Port     string
+}
+
+func (f ConnFilter) String() string {
+	if f.Protocol == "" {
+		return f.Source
+	}
+	if f.Port == "" {
+		return fmt.Sprintf("%s %s", f.Source, f.Protocol)
+	}
+	return fmt.Sprintf("%s %s/%s", f.Source, f.Protocol, f.Port)
+}
+
+// covers 判断 f 放行的流量是否包含 g 匹配的连接
+func (f ConnFilter) covers(g ConnFilter) bool {
+	if f.Source != g.Source {
+		return false
+	}
+	if f.Protocol == "" {
+		return true
+	}
+	return f.Protocol == g.Protocol && (f.Port == "" || f.Port == g.Port)
+}
+
+// EntryConnFilters 返回白名单条目放行的连接，ports 为空时匹配该IP的全部连接
+func EntryConnFilters(ip, ports string) ([]ConnFilter, error) {
+	portRules, err := ParsePorts(ports)
+	if err != nil {
+		return nil, err
+	}
+	if len(portRules) == 0 {
+		return []ConnFilter{{Source: ip}}, nil
+	}
+
+	filters := make([]ConnFilter, len(portRules))
+	for i, p := range portRules {
+		filters[i] = ConnFilter{Source: ip, Protocol: p.Protocol, Port: p.Port}
+	}
+	return filters, nil
+}
+
+// inputConnFilter 从受管的INPUT规则中提取其放行的连接
+func inputConnFilter(rule ManagedRule) (ConnFilter, bool) {
+	if rule.Chain != "INPUT" {
+		return ConnFilter{}, false
+	}
+
+	var f ConnFilter
+	for i := 0; i < len(rule.Args)-1; i++ {
+		switch rule.Args[i] {
+		case "-s":
+			f.Source = strings.TrimSuffix(rule.Args[i+1], "/32")
+		case "-p":
+			f.Protocol = rule.Args[i+1]
+		case "--dport":
+			f.Port = rule.Args[i+1]
+		}
+	}
+	return f, f.Source != ""
+}
+
+// FlushConnections 通过conntrack删除匹配的连接，返回删除的连接数。
+// conntrack不支持按端口范围删除，范围端口只按协议匹配
+func FlushConnections(f ConnFilter) (int, error) {
+	args := []string{"-D", "-s", f.Source}
+	if f.Protocol != "" {
+		args = append(args, "-p", f.Protocol)
+		if f.Port != "" && !strings.Contains(f.Port, ":") {
+			args = append(args, "--dport", f.Port)
+		}
+	}
+
+	output, err := exec.Command("conntrack", args...).CombinedOutput()
+	deleted := 0
+	if m := flowsDeletedPattern.FindSubmatch(output); m != nil {
+		deleted, _ = strconv.Atoi(string(m[1]))
+	}
+	// 没有匹配的连接时conntrack以非零状态退出，这不算错误
+	if err != nil && deleted == 0 && !strings.Contains(string(output), "0 flow entries") {
+		return 0, fmt.Errorf("conntrack %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return deleted, nil
+}
+
+// revokeConnections 在启用 flushOnRevoke 时断开被删除规则放行的连接。
+// 仍被其他受管白名单规则放行的连接（如同一IP的其他条目）会被保留
+func revokeConnections(removed []ManagedRule) {
+	if !flushOnRevoke || len(removed) == 0 {
+		return
+	}
+
+	rules, err := ListManagedRules()
+	if err != nil {
+		log.Printf("Failed to list rules before flushing connections: %v", err)
+		return
+	}
+	var remaining []ConnFilter
+	for _, rule := range rules {
+		if f, ok := inputConnFilter(rule); ok && rule.Kind == KindWhitelist {
+			remaining = append(remaining, f)
+		}
+	}
+
+	flushed := make(map[ConnFilter]bool)
+	for _, rule := range removed {
+		f, ok := inputConnFilter(rule)
+		if !ok || flushed[f] || isCovered(f, remaining) {
+			continue
+		}
+		flushed[f] = true
+
+		deleted, err := FlushConnections(f)
+		if err != nil {
+			log.Printf("Failed to flush connections of %s: %v", f, err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Flushed %d connection(s) of revoked %s", deleted, f)
+		}
+	}
+}
+
+func isCovered(f ConnFilter, filters []ConnFilter) bool {
+	for _, other := range filters {
+		if other.covers(f) {
+			return true
+		}
+	}
+	return false
+}
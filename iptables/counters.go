@@ -0,0 +1,140 @@
+package iptables
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/models"
+)
+
+// TrafficRetention 为流量采样在数据库中保留的时间
+const TrafficRetention = 7 * 24 * time.Hour
+
+// lastCounters 记录上次采样时各白名单条目的计数，用于计算增量。
+// 防火墙在启动时重建，所以进程启动后第一次采样的计数本身就是增量
+var lastCounters = make(map[int]models.TrafficStats)
+
+var (
+	resetMu sync.Mutex
+	// counterResets 记录上次采样之后规则被重建（计数归零）的条目
+	counterResets = make(map[int]bool)
+)
+
+// markCountersReset 在条目的规则被替换或删除后调用，下次采样时以当前计数作为增量
+func markCountersReset(id int) {
+	resetMu.Lock()
+	counterResets[id] = true
+	resetMu.Unlock()
+}
+
+// ReadCounters 读取 iptables -L -v -x -n 的计数，按条目ID汇总指定类型的受管规则。
+// INPUT链的计数为来自该地址的流量，OUTPUT链为发往该地址的流量
+func ReadCounters(kind string) (map[int]models.TrafficStats, error) {
+	counters := make(map[int]models.TrafficStats)
+	for _, chain := range managedChains {
+		output, err := exec.Command("iptables", iptablesArgs("-L", chain, "-v", "-x", "-n")...).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s counters: %v: %s", chain, err, string(output))
+		}
+
+		for _, line := range strings.Split(string(output), "\n") {
+			ruleKind, id, packets, bytes, ok := parseCounterLine(line)
+			if !ok || ruleKind != kind {
+				continue
+			}
+			c := counters[id]
+			switch chain {
+			case "INPUT":
+				c.PacketsIn += packets
+				c.BytesIn += bytes
+			case "OUTPUT":
+				c.PacketsOut += packets
+				c.BytesOut += bytes
+			}
+			counters[id] = c
+		}
+	}
+	return counters, nil
+}
+
+// parseCounterLine 解析 iptables -L -v -x -n 的一行，例如：
+// 120 9840 ACCEPT tcp -- * * 1.2.3.4 0.0.0.0/0 tcp dpt:22 /* iptables-safe:id=5 */
+func parseCounterLine(line string) (kind string, id int, packets, bytes int64, ok bool) {
+	start := strings.Index(line, "/* "+commentPrefix)
+	if start < 0 {
+		return "", 0, 0, 0, false
+	}
+	comment := line[start+3:]
+	if end := strings.Index(comment, " */"); end >= 0 {
+		comment = comment[:end]
+	}
+	kind, id, ok = parseComment(comment)
+	if !ok {
+		return "", 0, 0, 0, false
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", 0, 0, 0, false
+	}
+	packets, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", 0, 0, 0, false
+	}
+	bytes, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", 0, 0, 0, false
+	}
+	return kind, id, packets, bytes, true
+}
+
+// CollectTraffic 读取白名单规则的计数，把与上次采样的差值写入数据库，只记录有流量的条目。
+// 条目的规则被替换后计数会从0开始，此时直接以当前值作为增量。重建的规则在下次采样前
+// 可能已超过旧的计数，所以不能只看计数是否变小；计数变小仍视为归零，以覆盖手工清零等情况
+func CollectTraffic() error {
+	// 在读取计数之前取走标记，读取期间重建的规则留到下次采样处理
+	resetMu.Lock()
+	resets := counterResets
+	counterResets = make(map[int]bool)
+	resetMu.Unlock()
+
+	counters, err := ReadCounters(KindWhitelist)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var firstErr error
+	for id, current := range counters {
+		delta := current
+		if last, ok := lastCounters[id]; ok && !resets[id] && !counterReset(last, current) {
+			delta = models.TrafficStats{
+				PacketsIn:  current.PacketsIn - last.PacketsIn,
+				BytesIn:    current.BytesIn - last.BytesIn,
+				PacketsOut: current.PacketsOut - last.PacketsOut,
+				BytesOut:   current.BytesOut - last.BytesOut,
+			}
+		}
+		if delta.PacketsIn == 0 && delta.PacketsOut == 0 {
+			continue
+		}
+
+		sample := models.TrafficSample{TrafficStats: delta, SampledAt: now}
+		if err := database.AddTrafficSample(id, sample); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to save traffic of whitelist entry %d: %v", id, err)
+		}
+	}
+
+	lastCounters = counters
+	return firstErr
+}
+
+func counterReset(last, current models.TrafficStats) bool {
+	return current.PacketsIn < last.PacketsIn || current.BytesIn < last.BytesIn ||
+		current.PacketsOut < last.PacketsOut || current.BytesOut < last.BytesOut
+}
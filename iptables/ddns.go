@@ -0,0 +1,81 @@
+package iptables
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"iptables-safe/database"
+	"iptables-safe/geoip"
+)
+
+// ResolveHostname 解析域名白名单条目，返回排序后的第一个IPv4地址，
+// 使同一组解析结果总是选中同一个地址
+func ResolveHostname(host string) (string, error) {
+	records, err := dnsResolver.LookupA(host)
+	if err != nil {
+		return "", err
+	}
+
+	ips := make([]string, len(records))
+	for i, record := range records {
+		ips[i] = record.IP
+	}
+	sort.Strings(ips)
+	return ips[0], nil
+}
+
+// RefreshWhitelistHosts 重新解析动态DNS白名单条目。地址变化时按条目ID替换规则，
+// 即放行新地址并撤销旧地址，每次变化和每次失败都写入条目历史
+func RefreshWhitelistHosts() error {
+	entries, err := database.GetActiveHostnameEntries()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname entries: %v", err)
+	}
+
+	changed := false
+	for _, entry := range entries {
+		ip, err := ResolveHostname(entry.Hostname)
+		if err != nil {
+			log.Printf("Failed to resolve whitelist entry %d (%s): %v", entry.ID, entry.Hostname, err)
+			database.AddWhitelistHistory(entry.ID, "resolve_failed",
+				fmt.Sprintf("%s could not be resolved, keeping %s: %v", entry.Hostname, entry.IP, err))
+			continue
+		}
+		if ip == entry.IP {
+			continue
+		}
+
+		// 先更新防火墙再保存地址，保存失败时恢复旧地址的规则，数据库和防火墙始终一致
+		if err := AddIPToWhitelist(entry.ID, ip, entry.Ports); err != nil {
+			log.Printf("Failed to apply new address of whitelist entry %d: %v", entry.ID, err)
+			database.AddWhitelistHistory(entry.ID, "resolve_failed",
+				fmt.Sprintf("%s resolved to %s but the firewall could not be updated: %v", entry.Hostname, ip, err))
+			continue
+		}
+
+		if err := database.UpdateWhitelistAddress(entry.ID, ip, geoip.Country(ip)); err != nil {
+			log.Printf("Failed to update address of whitelist entry %d: %v", entry.ID, err)
+			if err := AddIPToWhitelist(entry.ID, entry.IP, entry.Ports); err != nil {
+				log.Printf("Failed to restore old address of whitelist entry %d: %v", entry.ID, err)
+			}
+			changed = true
+			database.AddWhitelistHistory(entry.ID, "resolve_failed",
+				fmt.Sprintf("%s resolved to %s but the address could not be saved: %v", entry.Hostname, ip, err))
+			continue
+		}
+
+		changed = true
+		log.Printf("Whitelist entry %d (%s) moved from %s to %s", entry.ID, entry.Hostname, entry.IP, ip)
+		database.AddWhitelistHistory(entry.ID, "address_changed",
+			fmt.Sprintf("%s: %s -> %s", entry.Hostname, entry.IP, ip))
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := SaveRules(); err != nil {
+		log.Printf("Error saving iptables rules: %v", err)
+	}
+	return nil
+}
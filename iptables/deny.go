@@ -0,0 +1,159 @@
+package iptables
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"iptables-safe/database"
+)
+
+// DenyChain 为黑名单规则所在的链。InitializeFirewall 把跳转到该链的规则放在INPUT链第一条，
+// 使黑名单先于公开端口、ESTABLISHED,RELATED和白名单规则生效
+const DenyChain = "IPTABLES-SAFE-DENY"
+
+// whitelistInsertPos 为白名单规则插入INPUT链的位置，紧跟在黑名单跳转规则之后
+const whitelistInsertPos = "2"
+
+// setupDenyChain 创建黑名单链并在INPUT链中跳转到它，需在添加其他INPUT规则之前调用
+func setupDenyChain() error {
+	if err := runCommand("iptables", "-N", DenyChain); err != nil {
+		return fmt.Errorf("failed to create chain %s: %v", DenyChain, err)
+	}
+	if err := runCommand("iptables", "-A", "INPUT", "-j", DenyChain); err != nil {
+		return fmt.Errorf("failed to jump to chain %s: %v", DenyChain, err)
+	}
+	return nil
+}
+
+// IsValidDenyAddress 黑名单地址可以是IP或CIDR
+func IsValidDenyAddress(s string) bool {
+	return isValidDestination(s)
+}
+
+// AddressContains 判断IP是否落在地址（IP或CIDR）内
+func AddressContains(address, ip string) bool {
+	target := net.ParseIP(ip)
+	if target == nil {
+		return false
+	}
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		return err == nil && network.Contains(target)
+	}
+	return target.Equal(net.ParseIP(address))
+}
+
+// ApplyDenyRule 为黑名单条目添加DROP规则，条目已有的规则会被替换
+func ApplyDenyRule(id int, address string) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return applyDropRule(KindDeny, id, address)
+}
+
+// RemoveDenyRule 删除黑名单条目的规则
+func RemoveDenyRule(id int) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return removeDropRule(KindDeny, id)
+}
+
+// ApplyBanRule 为自动封禁添加DROP规则，与黑名单位于同一条链
+func ApplyBanRule(id int, ip string) error {
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address: %s", ip)
+	}
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return applyDropRule(KindBan, id, ip)
+}
+
+// RemoveBanRule 删除自动封禁的规则
+func RemoveBanRule(id int) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return removeDropRule(KindBan, id)
+}
+
+func applyDropRule(kind string, id int, address string) error {
+	if !IsValidDenyAddress(address) {
+		return fmt.Errorf("invalid address: %s", address)
+	}
+
+	if _, err := removeManagedRules(kind, id); err != nil {
+		return fmt.Errorf("failed to replace rules of %s entry %d: %v", kind, id, err)
+	}
+
+	err := runCommand("iptables", "-A", DenyChain, "-s", address,
+		"-m", "comment", "--comment", ruleComment(kind, id), "-j", "DROP")
+	if err != nil {
+		return fmt.Errorf("failed to add %s rule for %s: %v", kind, address, err)
+	}
+
+	log.Printf("Dropping %s as %s entry %d", address, kind, id)
+	return nil
+}
+
+func removeDropRule(kind string, id int) error {
+	removed, err := removeManagedRules(kind, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s entry %d: %v", kind, id, err)
+	}
+
+	log.Printf("Removed %d rule(s) of %s entry %d", len(removed), kind, id)
+	return nil
+}
+
+// ReconcileDeny 删除已过期或已删除黑名单条目的规则，并补齐缺失的规则
+func ReconcileDeny() error {
+	entries, err := database.GetActiveDenyEntries()
+	if err != nil {
+		return fmt.Errorf("failed to get deny entries: %v", err)
+	}
+
+	addresses := make(map[int]string)
+	for _, entry := range entries {
+		addresses[entry.ID] = entry.Address
+	}
+	return reconcileDropRules(KindDeny, addresses)
+}
+
+// ReconcileBans 删除已过期或已解封的封禁规则，并补齐缺失的规则
+func ReconcileBans() error {
+	bans, err := database.GetActiveBans()
+	if err != nil {
+		return fmt.Errorf("failed to get bans: %v", err)
+	}
+
+	addresses := make(map[int]string)
+	for _, ban := range bans {
+		addresses[ban.ID] = ban.IP
+	}
+	return reconcileDropRules(KindBan, addresses)
+}
+
+// reconcileDropRules 使该类型的DROP规则与 addresses（条目ID到地址）一致
+func reconcileDropRules(kind string, addresses map[int]string) error {
+	active := make(map[int]bool)
+	for id := range addresses {
+		active[id] = true
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	applied, _, err := pruneManagedRules(kind, active)
+	if err != nil {
+		return err
+	}
+
+	for id, address := range addresses {
+		if applied[id] {
+			continue
+		}
+		if err := applyDropRule(kind, id, address); err != nil {
+			log.Printf("Failed to restore %s entry %d (%s): %v", kind, id, address, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package iptables
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/models"
+	"iptables-safe/resolver"
+)
+
+const (
+	// EgressGracePeriod 地址的TTL过期后仍保留规则的时间，避免解析抖动导致连接中断
+	EgressGracePeriod = 10 * time.Minute
+	// 过短的TTL按此下限处理，避免频繁查询
+	minEgressTTL = 30 * time.Second
+)
+
+var hostnamePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,63}$`)
+
+// dnsResolver 用于解析域名出站条目和域名白名单条目，可通过 SetResolver 指向其他DNS服务器
+var dnsResolver = resolver.New("")
+
+// SetResolver 设置解析域名条目使用的DNS服务器
+func SetResolver(r *resolver.Resolver) {
+	dnsResolver = r
+}
+
+// IsValidEgressDestination 出站目的地址可以是IP、CIDR或域名
+func IsValidEgressDestination(s string) bool {
+	return isValidDestination(s) || IsValidHostname(s)
+}
+
+func IsValidHostname(s string) bool {
+	return len(s) <= 253 && hostnamePattern.MatchString(s)
+}
+
+// ApplyEgressRule 为出站条目插入OUTPUT规则。IP和CIDR条目的已有规则会被替换；
+// 域名条目立即解析一次，之后由 RefreshEgressHosts 按TTL刷新
+func ApplyEgressRule(id int, destination, ports string) error {
+	portRules, err := ParsePorts(ports)
+	if err != nil {
+		return err
+	}
+
+	if !isValidDestination(destination) {
+		return refreshEgressHost(id, destination, portRules)
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return applyEgressAddress(id, destination, portRules)
+}
+
+// applyEgressAddress 用指向 destination 的规则替换IP或CIDR条目已有的规则
+func applyEgressAddress(id int, destination string, portRules []PortRule) error {
+	if _, err := removeManagedRules(KindEgress, id); err != nil {
+		return fmt.Errorf("failed to replace rules of egress entry %d: %v", id, err)
+	}
+	return insertEgressRules(id, []string{destination}, portRules)
+}
+
+func insertEgressRules(id int, addrs []string, portRules []PortRule) error {
+	comment := ruleComment(KindEgress, id)
+	for _, addr := range addrs {
+		matches := [][]string{nil}
+		if len(portRules) > 0 {
+			matches = matches[:0]
+			for _, p := range portRules {
+				matches = append(matches, []string{"-p", p.Protocol, "--dport", p.Port})
+			}
+		}
+
+		for _, match := range matches {
+			cmd := []string{"iptables", "-I", "OUTPUT", "1", "-d", addr}
+			cmd = append(cmd, match...)
+			cmd = append(cmd, "-m", "comment", "--comment", comment, "-j", "ACCEPT")
+			if err := runCommand(cmd...); err != nil {
+				return fmt.Errorf("failed to add egress rule for %s: %v", addr, err)
+			}
+		}
+	}
+
+	if len(addrs) > 0 {
+		log.Printf("Applied egress entry %d to %s", id, strings.Join(addrs, ","))
+	}
+	return nil
+}
+
+// syncEgressAddresses 使域名条目的规则与期望的地址集合一致，
+// 只增删有变化的地址，未变化地址上的连接不受影响
+func syncEgressAddresses(id int, addrs []string, portRules []PortRule) error {
+	rules, err := ListManagedRules()
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for _, addr := range addrs {
+		wanted[addr] = true
+	}
+
+	present := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Kind != KindEgress || rule.ID != id {
+			continue
+		}
+		addr := ruleDestination(rule)
+		if wanted[addr] {
+			present[addr] = true
+			continue
+		}
+		if err := deleteRule(rule); err != nil {
+			log.Printf("Failed to remove stale address %s of egress entry %d: %v", addr, id, err)
+			continue
+		}
+		log.Printf("Removed stale address %s of egress entry %d", addr, id)
+	}
+
+	var missing []string
+	for _, addr := range addrs {
+		if !present[addr] {
+			missing = append(missing, addr)
+		}
+	}
+	return insertEgressRules(id, missing, portRules)
+}
+
+// ruleDestination 返回规则 -d 参数中的地址，单个主机地址去掉 /32 后缀
+func ruleDestination(rule ManagedRule) string {
+	for i := 0; i < len(rule.Args)-1; i++ {
+		if rule.Args[i] == "-d" {
+			return strings.TrimSuffix(rule.Args[i+1], "/32")
+		}
+	}
+	return ""
+}
+
+// refreshEgressHost 解析域名并记录TTL，删除超过宽限期仍未再次出现的地址，然后同步规则。
+// 解析失败时保留已有地址，直到它们超过宽限期
+func refreshEgressHost(id int, host string, portRules []PortRule) error {
+	records, resolveErr := dnsResolver.LookupA(host)
+	addrs, err := recordEgressAddresses(id, records, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update addresses of %s: %v", host, err)
+	}
+	rulesMu.Lock()
+	err = syncEgressAddresses(id, addressList(addrs), portRules)
+	rulesMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if resolveErr != nil && len(addrs) == 0 {
+		return resolveErr
+	}
+	if resolveErr != nil {
+		log.Printf("Warning: %v, keeping %d known address(es)", resolveErr, len(addrs))
+	}
+	return nil
+}
+
+// recordEgressAddresses 保存 now 时解析到的地址，删除TTL到期后超过宽限期仍未再次出现的地址，
+// 返回保留下来的全部地址
+func recordEgressAddresses(id int, records []resolver.Record, now time.Time) ([]models.EgressAddress, error) {
+	for _, record := range records {
+		ttl := record.TTL
+		if ttl < minEgressTTL {
+			ttl = minEgressTTL
+		}
+		if err := database.SaveEgressAddress(id, record.IP, ttl, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := database.DeleteStaleEgressAddresses(id, now.Add(-EgressGracePeriod)); err != nil {
+		return nil, err
+	}
+	return database.GetEgressAddresses(id)
+}
+
+func addressList(addrs []models.EgressAddress) []string {
+	list := make([]string, len(addrs))
+	for i, a := range addrs {
+		list[i] = a.Address
+	}
+	return list
+}
+
+// RefreshEgressHosts 重新解析已到期的域名出站条目。
+// 条目的地址中最早的TTL到期后才会再次查询
+func RefreshEgressHosts() error {
+	entries, err := database.GetActiveEgressRules()
+	if err != nil {
+		return fmt.Errorf("failed to get egress rules: %v", err)
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		if isValidDestination(entry.Destination) {
+			continue
+		}
+
+		addrs, err := database.GetEgressAddresses(entry.ID)
+		if err != nil {
+			log.Printf("Failed to get addresses of %s: %v", entry.Destination, err)
+			continue
+		}
+		if !refreshDue(addrs, now) {
+			continue
+		}
+
+		portRules, err := ParsePorts(entry.Ports)
+		if err != nil {
+			log.Printf("Invalid ports of egress entry %d: %v", entry.ID, err)
+			continue
+		}
+		if err := refreshEgressHost(entry.ID, entry.Destination, portRules); err != nil {
+			log.Printf("Failed to refresh egress entry %d (%s): %v", entry.ID, entry.Destination, err)
+		}
+	}
+	return nil
+}
+
+func refreshDue(addrs []models.EgressAddress, now time.Time) bool {
+	if len(addrs) == 0 {
+		return true
+	}
+	for _, a := range addrs {
+		if !a.ExpiresAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveEgressRule 删除出站条目的全部规则
+func RemoveEgressRule(id int) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	removed, err := removeManagedRules(KindEgress, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove egress entry %d: %v", id, err)
+	}
+
+	log.Printf("Removed %d rule(s) of egress entry %d", len(removed), id)
+	return nil
+}
+
+// ReconcileEgress 删除已过期或已删除出站条目的规则，并补齐缺失的规则。
+// 域名条目使用数据库中已记录的地址，不会重新解析
+func ReconcileEgress() error {
+	entries, err := database.GetActiveEgressRules()
+	if err != nil {
+		return fmt.Errorf("failed to get egress rules: %v", err)
+	}
+
+	active := make(map[int]bool)
+	for _, entry := range entries {
+		active[entry.ID] = true
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	applied, _, err := pruneManagedRules(KindEgress, active)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if isValidDestination(entry.Destination) {
+			if applied[entry.ID] {
+				continue
+			}
+			portRules, err := ParsePorts(entry.Ports)
+			if err == nil {
+				err = applyEgressAddress(entry.ID, entry.Destination, portRules)
+			}
+			if err != nil {
+				log.Printf("Failed to restore egress entry %d (%s): %v", entry.ID, entry.Destination, err)
+			}
+			continue
+		}
+
+		portRules, err := ParsePorts(entry.Ports)
+		if err != nil {
+			log.Printf("Invalid ports of egress entry %d: %v", entry.ID, err)
+			continue
+		}
+		addrs, err := database.GetEgressAddresses(entry.ID)
+		if err != nil {
+			log.Printf("Failed to get addresses of %s: %v", entry.Destination, err)
+			continue
+		}
+		if err := syncEgressAddresses(entry.ID, addressList(addrs), portRules); err != nil {
+			log.Printf("Failed to restore egress entry %d (%s): %v", entry.ID, entry.Destination, err)
+		}
+	}
+	return nil
+}
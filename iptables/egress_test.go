@@ -0,0 +1,82 @@
+package iptables
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"iptables-safe/database"
+	"iptables-safe/resolver"
+)
+
+func TestRecordEgressAddressesGracePeriod(t *testing.T) {
+	if err := database.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	defer database.DB.Close()
+
+	const id = 1
+	start := time.Now()
+	addrs, err := recordEgressAddresses(id, []resolver.Record{
+		{IP: "192.0.2.1", TTL: 5 * time.Minute},
+		{IP: "192.0.2.2", TTL: 10 * time.Second},
+	}, start)
+	if err != nil {
+		t.Fatalf("failed to record addresses: %v", err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("got %d addresses, want 2", len(addrs))
+	}
+	for _, a := range addrs {
+		want := 300
+		if a.Address == "192.0.2.2" {
+			// 过短的TTL按 minEgressTTL 记录
+			want = int(minEgressTTL / time.Second)
+		}
+		if a.TTLSeconds != want {
+			t.Errorf("%s has TTL %d, want %d", a.Address, a.TTLSeconds, want)
+		}
+	}
+
+	// 域名改为指向新地址，旧地址的TTL已到期但仍在宽限期内
+	later := start.Add(5*time.Minute + EgressGracePeriod/2)
+	addrs, err = recordEgressAddresses(id, []resolver.Record{{IP: "192.0.2.3", TTL: 5 * time.Minute}}, later)
+	if err != nil {
+		t.Fatalf("failed to record addresses: %v", err)
+	}
+	if got := addressList(addrs); len(got) != 3 {
+		t.Fatalf("got %v, want old addresses kept within the grace period", got)
+	}
+
+	// 超过宽限期后只剩仍在解析结果中的地址
+	expired := start.Add(5*time.Minute + EgressGracePeriod + time.Second)
+	addrs, err = recordEgressAddresses(id, []resolver.Record{{IP: "192.0.2.3", TTL: 5 * time.Minute}}, expired)
+	if err != nil {
+		t.Fatalf("failed to record addresses: %v", err)
+	}
+	if got := addressList(addrs); len(got) != 1 || got[0] != "192.0.2.3" {
+		t.Fatalf("got %v, want only 192.0.2.3 after the grace period", got)
+	}
+}
+
+func TestRecordEgressAddressesKeepsAddressesOnFailure(t *testing.T) {
+	if err := database.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("failed to init database: %v", err)
+	}
+	defer database.DB.Close()
+
+	const id = 1
+	start := time.Now()
+	if _, err := recordEgressAddresses(id, []resolver.Record{{IP: "192.0.2.1", TTL: time.Minute}}, start); err != nil {
+		t.Fatalf("failed to record addresses: %v", err)
+	}
+
+	// 解析失败时没有新记录，已有地址保留到宽限期结束
+	addrs, err := recordEgressAddresses(id, nil, start.Add(time.Minute+EgressGracePeriod-time.Second))
+	if err != nil {
+		t.Fatalf("failed to record addresses: %v", err)
+	}
+	if len(addrs) != 1 {
+		t.Fatalf("got %d addresses, want the known address kept", len(addrs))
+	}
+}
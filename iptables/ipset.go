@@ -0,0 +1,142 @@
+package iptables
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const (
+	minSetSize = 65536
+	// blocklistSetPrefix 为列表ipset名称的前缀
+	blocklistSetPrefix = "iptables-safe-bl-"
+)
+
+// BlocklistSetName 返回威胁情报列表对应的ipset名称
+func BlocklistSetName(id int) string {
+	return fmt.Sprintf("%s%d", blocklistSetPrefix, id)
+}
+
+// BlocklistSetEmpty 返回列表的ipset是否不存在或没有内容，重启后ipset会丢失
+func BlocklistSetEmpty(id int) bool {
+	output, err := exec.Command("ipset", "-terse", "list", BlocklistSetName(id)).CombinedOutput()
+	if err != nil {
+		return true
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if count, ok := strings.CutPrefix(line, "Number of entries:"); ok {
+			return strings.TrimSpace(count) == "0"
+		}
+	}
+	return true
+}
+
+// ensureBlocklistSet 创建列表对应的ipset，已存在时保持原内容
+func ensureBlocklistSet(id int) error {
+	return runCommand("ipset", "create", BlocklistSetName(id), "hash:net", "family", "inet",
+		"maxelem", fmt.Sprint(minSetSize), "-exist")
+}
+
+// ReplaceBlocklistSet 用新的网段列表替换ipset内容。先写入临时集合再交换，
+// 替换过程中规则始终引用一个完整的集合
+func ReplaceBlocklistSet(id int, entries []string) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	if err := ensureBlocklistSet(id); err != nil {
+		return fmt.Errorf("failed to create ipset: %v", err)
+	}
+
+	name := BlocklistSetName(id)
+	tmp := name + "-tmp"
+	size := minSetSize
+	for size < len(entries) {
+		size *= 2
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "create %s hash:net family inet maxelem %d -exist\n", tmp, size)
+	fmt.Fprintf(&script, "flush %s\n", tmp)
+	for _, entry := range entries {
+		fmt.Fprintf(&script, "add %s %s -exist\n", tmp, entry)
+	}
+	fmt.Fprintf(&script, "swap %s %s\n", tmp, name)
+	fmt.Fprintf(&script, "destroy %s\n", tmp)
+
+	cmd := exec.Command("ipset", "restore")
+	cmd.Stdin = strings.NewReader(script.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		runCommand("ipset", "destroy", tmp)
+		return fmt.Errorf("ipset restore failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ApplyBlocklistRule 在黑名单链中添加匹配列表ipset的DROP规则
+func ApplyBlocklistRule(id int) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return applyBlocklistRule(id)
+}
+
+func applyBlocklistRule(id int) error {
+	if err := ensureBlocklistSet(id); err != nil {
+		return fmt.Errorf("failed to create ipset: %v", err)
+	}
+
+	if _, err := removeManagedRules(KindBlocklist, id); err != nil {
+		return fmt.Errorf("failed to replace rules of blocklist %d: %v", id, err)
+	}
+
+	err := runCommand("iptables", "-A", DenyChain, "-m", "set", "--match-set", BlocklistSetName(id), "src",
+		"-m", "comment", "--comment", ruleComment(KindBlocklist, id), "-j", "DROP")
+	if err != nil {
+		return fmt.Errorf("failed to add blocklist rule: %v", err)
+	}
+	return nil
+}
+
+// RemoveBlocklist 删除列表的规则和ipset
+func RemoveBlocklist(id int) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	if _, err := removeManagedRules(KindBlocklist, id); err != nil {
+		return fmt.Errorf("failed to remove blocklist %d: %v", id, err)
+	}
+	if err := runCommand("ipset", "destroy", BlocklistSetName(id)); err != nil {
+		log.Printf("Warning: failed to destroy ipset of blocklist %d: %v", id, err)
+	}
+	log.Printf("Removed blocklist %d", id)
+	return nil
+}
+
+// ReconcileBlocklists 使黑名单链中的列表规则与启用的列表一致。
+// 程序重启时ipset保留上次导入的内容；系统重启后ipset为空，需要由调用方重新导入
+func ReconcileBlocklists(enabled []int) error {
+	active := make(map[int]bool)
+	for _, id := range enabled {
+		active[id] = true
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	applied, removed, err := pruneManagedRules(KindBlocklist, active)
+	if err != nil {
+		return err
+	}
+	for _, rule := range removed {
+		runCommand("ipset", "destroy", BlocklistSetName(rule.ID))
+	}
+
+	for _, id := range enabled {
+		if applied[id] {
+			continue
+		}
+		if err := applyBlocklistRule(id); err != nil {
+			log.Printf("Failed to restore blocklist %d: %v", id, err)
+		}
+	}
+	return nil
+}
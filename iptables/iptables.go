@@ -5,13 +5,58 @@ import (
 	"log"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"iptables-safe/database"
 )
 
-func InitializeFirewall() error {
+// InitializeFirewall 按基础规则集重建防火墙，并从数据库恢复白名单
+func InitializeFirewall(policy *BasePolicy) error {
 	log.Println("Initializing firewall rules...")
 
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid base policy: %v", err)
+	}
+	flushOnRevoke = policy.FlushConnectionsOnRevoke
+	publicPorts, _ = ParsePorts(strings.Join(policy.PublicPorts, ","))
+
+	rulesMu.Lock()
+	err := resetRules(policy)
+	rulesMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Firewall initialized successfully")
+
+	// 从数据库加载活跃的白名单IP
+	if err := LoadWhitelistFromDB(); err != nil {
+		log.Printf("Warning: Failed to load whitelist from database: %v", err)
+	}
+
+	// 恢复黑名单
+	if err := ReconcileDeny(); err != nil {
+		log.Printf("Warning: Failed to load deny list from database: %v", err)
+	}
+	if err := ReconcileBans(); err != nil {
+		log.Printf("Warning: Failed to load bans from database: %v", err)
+	}
+
+	// 恢复端口敲门序列
+	if err := ReconcileKnock(); err != nil {
+		log.Printf("Warning: Failed to apply knock sequence: %v", err)
+	}
+
+	// 恢复受管的出站条目
+	if err := ReconcileEgress(); err != nil {
+		log.Printf("Warning: Failed to load egress rules from database: %v", err)
+	}
+
+	return nil
+}
+
+// resetRules 清空全部规则后按基础规则集重建，白名单等受管规则由调用方随后恢复
+func resetRules(policy *BasePolicy) error {
 	// 第一步：清空规则，先保持OUTPUT ACCEPT防止SSH断连
 	initCommands := [][]string{
 		{"iptables", "-F"},
@@ -27,9 +72,13 @@ func InitializeFirewall() error {
 		}
 	}
 
-	// 第二步：添加INPUT链基础规则（只开放8888管理端口，22端口通过白名单IP开放）
-	runCommand("iptables", "-A", "INPUT", "-i", "lo", "-j", "ACCEPT")
-	runCommand("iptables", "-A", "INPUT", "-p", "tcp", "--dport", "8888", "-j", "ACCEPT")
+	// 黑名单链位于INPUT链最前面，优先于下面的所有放行规则
+	if err := setupDenyChain(); err != nil {
+		return err
+	}
+
+	// 第二步：添加INPUT链基础规则（公开端口、回环和ICMP，其余端口通过白名单IP开放）
+	appendRules("INPUT", policy.inputRules())
 
 	// 第三步：添加ESTABLISHED,RELATED规则（兼容state和conntrack模块）
 	if err := runCommand("iptables", "-A", "INPUT", "-m", "state", "--state", "ESTABLISHED,RELATED", "-j", "ACCEPT"); err != nil {
@@ -39,12 +88,13 @@ func InitializeFirewall() error {
 		}
 	}
 
-	// 第四步：添加OUTPUT链规则
-	runCommand("iptables", "-A", "OUTPUT", "-o", "lo", "-j", "ACCEPT")
-	runCommand("iptables", "-A", "OUTPUT", "-p", "udp", "--dport", "53", "-j", "ACCEPT")
-	runCommand("iptables", "-A", "OUTPUT", "-p", "tcp", "--dport", "53", "-j", "ACCEPT")
-	// 允许Web管理服务回复客户端（源端口8888的出站流量）
-	runCommand("iptables", "-A", "OUTPUT", "-p", "tcp", "--sport", "8888", "-j", "ACCEPT")
+	// 敲门链位于INPUT链最后，只处理将被丢弃的包
+	if err := setupKnockChain(); err != nil {
+		return err
+	}
+
+	// 第四步：添加OUTPUT链规则（允许的出站目的地址，以及公开端口的回复流量）
+	appendRules("OUTPUT", policy.outputRules())
 
 	if err := runCommand("iptables", "-A", "OUTPUT", "-m", "state", "--state", "ESTABLISHED,RELATED", "-j", "ACCEPT"); err != nil {
 		log.Println("state module not available for OUTPUT, trying conntrack...")
@@ -57,34 +107,35 @@ func InitializeFirewall() error {
 	if err := runCommand("iptables", "-P", "OUTPUT", "DROP"); err != nil {
 		return fmt.Errorf("failed to set OUTPUT policy to DROP: %v", err)
 	}
+	return nil
+}
 
-	log.Println("Firewall initialized successfully")
-
-	// 从数据库加载活跃的白名单IP
-	if err := LoadWhitelistFromDB(); err != nil {
-		log.Printf("Warning: Failed to load whitelist from database: %v", err)
+func appendRules(chain string, rules [][]string) {
+	for _, rule := range rules {
+		args := append([]string{"iptables", "-A", chain}, rule...)
+		if err := runCommand(args...); err != nil {
+			log.Printf("Warning: failed to add %s rule %v: %v", chain, rule, err)
+		}
 	}
-
-	return nil
 }
 
 func LoadWhitelistFromDB() error {
 	log.Println("Loading whitelist IPs from database...")
 
-	ips, err := database.GetActiveWhitelistIPs()
+	entries, err := database.GetActiveWhitelistIPs()
 	if err != nil {
 		return fmt.Errorf("failed to get whitelist IPs: %v", err)
 	}
 
-	if len(ips) == 0 {
+	if len(entries) == 0 {
 		log.Println("No whitelist IPs to load")
 		return nil
 	}
 
 	loaded := 0
-	for _, ip := range ips {
-		if err := AddIPToWhitelist(ip); err != nil {
-			log.Printf("Failed to add IP %s to whitelist: %v", ip, err)
+	for _, entry := range entries {
+		if err := AddIPToWhitelist(entry.ID, entry.IP, entry.Ports); err != nil {
+			log.Printf("Failed to add IP %s to whitelist: %v", entry.IP, err)
 			continue
 		}
 		loaded++
@@ -94,79 +145,136 @@ func LoadWhitelistFromDB() error {
 	return nil
 }
 
-func AddIPToWhitelist(ip string) error {
+// AddIPToWhitelist 为白名单条目插入规则，规则通过注释携带条目ID，
+// 以便与管理员手工添加的相同规则区分开。ports 为空时放行该IP的全部端口，
+// 否则只放行列出的服务。条目已有的规则会先被替换
+func AddIPToWhitelist(id int, ip, ports string) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	return addIPToWhitelist(id, ip, ports)
+}
+
+func addIPToWhitelist(id int, ip, ports string) error {
 	if !isValidIP(ip) {
 		return fmt.Errorf("invalid IP address: %s", ip)
 	}
 
-	if IsIPWhitelisted(ip) {
-		log.Printf("IP %s is already whitelisted", ip)
-		return nil
+	portRules, err := ParsePorts(ports)
+	if err != nil {
+		return err
 	}
 
-	// INPUT链：允许该IP入站
-	cmd := []string{"iptables", "-I", "INPUT", "1", "-s", ip, "-j", "ACCEPT"}
-	if err := runCommand(cmd...); err != nil {
-		return fmt.Errorf("failed to add IP %s to INPUT whitelist: %v", ip, err)
+	removed, err := removeManagedRules(KindWhitelist, id)
+	if err != nil {
+		return fmt.Errorf("failed to replace rules of whitelist entry %d: %v", id, err)
 	}
+	markCountersReset(id)
+
+	comment := ruleComment(KindWhitelist, id)
 
-	// OUTPUT链：允许向该IP出站
-	cmdOut := []string{"iptables", "-I", "OUTPUT", "1", "-d", ip, "-j", "ACCEPT"}
-	if err := runCommand(cmdOut...); err != nil {
-		log.Printf("Warning: failed to add IP %s to OUTPUT whitelist: %v", ip, err)
+	// 未限制端口时使用一条不带协议匹配的规则
+	matches := [][]string{nil}
+	if len(portRules) > 0 {
+		matches = matches[:0]
+		for _, p := range portRules {
+			matches = append(matches, []string{"-p", p.Protocol, "--dport", p.Port})
+		}
 	}
 
-	log.Printf("Added IP %s to whitelist (INPUT+OUTPUT)", ip)
-	return nil
-}
+	for _, match := range matches {
+		// INPUT链：允许该IP入站
+		cmd := []string{"iptables", "-I", "INPUT", whitelistInsertPos, "-s", ip}
+		cmd = append(cmd, match...)
+		cmd = append(cmd, "-m", "comment", "--comment", comment, "-j", "ACCEPT")
+		if err := runCommand(cmd...); err != nil {
+			return fmt.Errorf("failed to add IP %s to INPUT whitelist: %v", ip, err)
+		}
 
-func RemoveIPFromWhitelist(ip string) error {
-	if !isValidIP(ip) {
-		return fmt.Errorf("invalid IP address: %s", ip)
+		// OUTPUT链：允许向该IP回复（端口方向与入站相反）
+		cmdOut := []string{"iptables", "-I", "OUTPUT", "1", "-d", ip}
+		if match != nil {
+			cmdOut = append(cmdOut, "-p", match[1], "--sport", match[3])
+		}
+		cmdOut = append(cmdOut, "-m", "comment", "--comment", comment, "-j", "ACCEPT")
+		if err := runCommand(cmdOut...); err != nil {
+			log.Printf("Warning: failed to add IP %s to OUTPUT whitelist: %v", ip, err)
+		}
 	}
 
-	// 删除INPUT链规则
-	cmd := []string{"iptables", "-D", "INPUT", "-s", ip, "-j", "ACCEPT"}
-	if err := runCommand(cmd...); err != nil {
-		return fmt.Errorf("failed to remove IP %s from INPUT whitelist: %v", ip, err)
+	if ports == "" {
+		log.Printf("Added IP %s to whitelist as entry %d (all ports)", ip, id)
+	} else {
+		log.Printf("Added IP %s to whitelist as entry %d (%s)", ip, id, FormatPorts(portRules))
 	}
 
-	// 删除OUTPUT链规则
-	cmdOut := []string{"iptables", "-D", "OUTPUT", "-d", ip, "-j", "ACCEPT"}
-	if err := runCommand(cmdOut...); err != nil {
-		log.Printf("Warning: failed to remove IP %s from OUTPUT whitelist: %v", ip, err)
+	// 地址或端口变化时，断开不再被放行的旧连接
+	revokeConnections(removed)
+	return nil
+}
+
+// RemoveIPFromWhitelist 删除带有该条目ID注释的全部规则，不会触碰手工添加的规则
+func RemoveIPFromWhitelist(id int) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	removed, err := removeManagedRules(KindWhitelist, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove whitelist entry %d: %v", id, err)
+	}
+	markCountersReset(id)
+	if len(removed) == 0 {
+		log.Printf("Whitelist entry %d has no rules to remove", id)
+		return nil
 	}
 
-	log.Printf("Removed IP %s from whitelist (INPUT+OUTPUT)", ip)
+	log.Printf("Removed %d rule(s) of whitelist entry %d", len(removed), id)
+	revokeConnections(removed)
 	return nil
 }
 
-func IsIPWhitelisted(ip string) bool {
-	cmd := exec.Command("iptables", "-L", "INPUT", "-n")
-	output, err := cmd.CombinedOutput()
+// ReconcileWhitelist 使iptables中的受管规则与数据库中的活跃条目保持一致：
+// 删除已过期或已删除条目的规则，并补齐缺失的规则
+func ReconcileWhitelist() error {
+	entries, err := database.GetActiveWhitelistIPs()
 	if err != nil {
-		log.Printf("Failed to check iptables rules: %v", err)
-		return false
+		return fmt.Errorf("failed to get whitelist IPs: %v", err)
 	}
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "ACCEPT") {
-			fields := strings.Fields(line)
-			for _, field := range fields {
-				if field == ip {
-					return true
-				}
-			}
+	active := make(map[int]bool)
+	for _, entry := range entries {
+		active[entry.ID] = true
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	applied, removed, err := pruneManagedRules(KindWhitelist, active)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if applied[entry.ID] {
+			continue
+		}
+		if err := addIPToWhitelist(entry.ID, entry.IP, entry.Ports); err != nil {
+			log.Printf("Failed to restore whitelist entry %d (%s): %v", entry.ID, entry.IP, err)
 		}
 	}
-	return false
+
+	revokeConnections(removed)
+	return nil
 }
 
+// SaveRules 保存当前规则供开机恢复。列表规则引用的ipset不随规则保存，
+// 开机恢复时集合不存在会导致整个文件恢复失败，因此不保存这些规则，由程序启动时重新加入
 func SaveRules() error {
-	cmd := exec.Command("sh", "-c", "iptables-save > /etc/sysconfig/iptables")
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	save := fmt.Sprintf("iptables-save | grep -v -- '--match-set %s'", blocklistSetPrefix)
+	cmd := exec.Command("sh", "-c", save+" > /etc/sysconfig/iptables")
 	if err := cmd.Run(); err != nil {
-		cmd = exec.Command("sh", "-c", "iptables-save > /etc/iptables/rules.v4")
+		cmd = exec.Command("sh", "-c", save+" > /etc/iptables/rules.v4")
 		if err := cmd.Run(); err != nil {
 			return fmt.Errorf("failed to save iptables rules: %v", err)
 		}
@@ -176,6 +284,9 @@ func SaveRules() error {
 }
 
 func runCommand(args ...string) error {
+	if args[0] == "iptables" {
+		args = append([]string{"iptables"}, iptablesArgs(args[1:]...)...)
+	}
 	cmd := exec.Command(args[0], args[1:]...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -184,6 +295,28 @@ func runCommand(args ...string) error {
 	return nil
 }
 
+var (
+	waitOnce      sync.Once
+	waitSupported bool
+)
+
+// iptablesArgs 在iptables支持时加上 -w，等待其他程序（如fail2ban、firewalld）释放xtables锁，
+// 而不是立即失败。CentOS 6 自带的iptables 1.4.7 不支持该参数，此时原样返回
+func iptablesArgs(args ...string) []string {
+	waitOnce.Do(func() {
+		waitSupported = exec.Command("iptables", "-w", "-S", "INPUT").Run() == nil
+	})
+	if !waitSupported {
+		return args
+	}
+	return append([]string{"-w"}, args...)
+}
+
+// IsValidWhitelistIP 白名单条目目前只支持单个IPv4地址
+func IsValidWhitelistIP(ip string) bool {
+	return isValidIP(ip)
+}
+
 func isValidIP(ip string) bool {
 	// 拒绝空字符串
 	if ip == "" {
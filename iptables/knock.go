@@ -0,0 +1,254 @@
+package iptables
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iptables-safe/database"
+)
+
+// KnockChain 为端口敲门规则所在的链，INPUT链的最后一条规则跳转到该链，
+// 只有将被默认策略丢弃的包才会进入，敲门端口对外始终表现为关闭
+const KnockChain = "IPTABLES-SAFE-KNOCK"
+
+// KnockStepTimeout 为相邻两次敲门之间允许的最长间隔
+const KnockStepTimeout = 10 * time.Second
+
+// 敲门序列的长度限制
+const (
+	MinKnockSteps = 2
+	MaxKnockSteps = 8
+)
+
+// 随机生成的敲门端口范围，避开常用服务端口
+const (
+	knockPortMin = 10000
+	knockPortMax = 60000
+)
+
+// xt_recent 列表：knockListPrefix<N> 记录完成了第N步的地址，knockDoneList 记录完成全部序列的地址
+const (
+	knockListPrefix = "iptables-safe-knock"
+	knockDoneList   = "iptables-safe-knock-done"
+	xtRecentDir     = "/proc/net/xt_recent/"
+)
+
+var (
+	knockMu sync.Mutex
+	// knockSteps 为当前生效的敲门序列长度，0表示未启用
+	knockSteps int
+	// publicPorts 为基础规则中对所有来源开放的端口，敲门端口不能与之重叠
+	publicPorts []PortRule
+)
+
+// ParseKnockSequence 解析敲门序列，如 "tcp/7000,udp/8123,tcp/9001"，每一步只能是单个端口
+func ParseKnockSequence(s string) ([]PortRule, error) {
+	steps, err := ParsePorts(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) < MinKnockSteps || len(steps) > MaxKnockSteps {
+		return nil, fmt.Errorf("knock sequence must have %d to %d steps", MinKnockSteps, MaxKnockSteps)
+	}
+	for i, step := range steps {
+		if strings.Contains(step.Port, ":") {
+			return nil, fmt.Errorf("knock step %q must be a single port", step)
+		}
+		port, _ := strconv.Atoi(step.Port)
+		for _, public := range publicPorts {
+			if public.Matches(step.Protocol, port) {
+				return nil, fmt.Errorf("knock step %q is a public port", step)
+			}
+		}
+		if i > 0 && steps[i-1] == step {
+			return nil, fmt.Errorf("knock step %q repeats the previous step", step)
+		}
+	}
+	return steps, nil
+}
+
+// RandomKnockSequence 生成指定长度的随机敲门序列，协议在TCP和UDP之间随机选择
+func RandomKnockSequence(length int) (string, error) {
+	if length < MinKnockSteps || length > MaxKnockSteps {
+		return "", fmt.Errorf("knock sequence must have %d to %d steps", MinKnockSteps, MaxKnockSteps)
+	}
+
+	for {
+		steps := make([]PortRule, length)
+		for i := range steps {
+			n, err := rand.Int(rand.Reader, big.NewInt(2*(knockPortMax-knockPortMin+1)))
+			if err != nil {
+				return "", err
+			}
+			v := int(n.Int64())
+			protocol := "tcp"
+			if v%2 == 1 {
+				protocol = "udp"
+			}
+			steps[i] = PortRule{Protocol: protocol, Port: strconv.Itoa(knockPortMin + v/2)}
+		}
+		sequence := FormatPorts(steps)
+		if _, err := ParseKnockSequence(sequence); err == nil {
+			return sequence, nil
+		}
+	}
+}
+
+func knockListName(step int) string {
+	return fmt.Sprintf("%s%d", knockListPrefix, step)
+}
+
+// setupKnockChain 创建敲门链并在INPUT链末尾跳转到它，需在添加完其他INPUT规则之后调用
+func setupKnockChain() error {
+	if err := runCommand("iptables", "-N", KnockChain); err != nil {
+		return fmt.Errorf("failed to create chain %s: %v", KnockChain, err)
+	}
+	if err := runCommand("iptables", "-A", "INPUT", "-j", KnockChain); err != nil {
+		return fmt.Errorf("failed to jump to chain %s: %v", KnockChain, err)
+	}
+	return nil
+}
+
+// ApplyKnockSequence 按序列重建敲门链，steps 为空时清空敲门链即关闭敲门。
+// 每一步要求上一步在 KnockStepTimeout 内完成；敲错端口会清除该地址的进度
+func ApplyKnockSequence(steps []PortRule) error {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	knockMu.Lock()
+	defer knockMu.Unlock()
+
+	if err := runCommand("iptables", "-F", KnockChain); err != nil {
+		return fmt.Errorf("failed to flush chain %s: %v", KnockChain, err)
+	}
+	knockSteps = 0
+	if len(steps) == 0 {
+		return nil
+	}
+
+	seconds := strconv.Itoa(int(KnockStepTimeout.Seconds()))
+	var rules [][]string
+	for i, step := range steps {
+		rule := []string{"-p", step.Protocol, "--dport", step.Port}
+		if i > 0 {
+			rule = append(rule, "-m", "recent", "--name", knockListName(i), "--rcheck", "--seconds", seconds)
+		}
+		list := knockListName(i + 1)
+		if i == len(steps)-1 {
+			list = knockDoneList
+		}
+		rules = append(rules, append(rule, "-m", "recent", "--name", list, "--set", "-j", "DROP"))
+	}
+	for i := 1; i < len(steps); i++ {
+		rules = append(rules, []string{"-m", "recent", "--name", knockListName(i), "--remove"})
+	}
+
+	for _, rule := range rules {
+		args := append([]string{"iptables", "-A", KnockChain}, rule...)
+		if err := runCommand(args...); err != nil {
+			runCommand("iptables", "-F", KnockChain)
+			return fmt.Errorf("failed to add knock rule: %v", err)
+		}
+	}
+	knockSteps = len(steps)
+	return nil
+}
+
+// ReconcileKnock 按数据库中的配置重建敲门链
+func ReconcileKnock() error {
+	config, err := database.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get config: %v", err)
+	}
+	if config.KnockSequence == "" {
+		return ApplyKnockSequence(nil)
+	}
+
+	steps, err := ParseKnockSequence(config.KnockSequence)
+	if err != nil {
+		ApplyKnockSequence(nil)
+		return fmt.Errorf("invalid knock sequence %q: %v", config.KnockSequence, err)
+	}
+	return ApplyKnockSequence(steps)
+}
+
+// KnockEnabled 返回当前是否启用了敲门
+func KnockEnabled() bool {
+	knockMu.Lock()
+	defer knockMu.Unlock()
+	return knockSteps > 0
+}
+
+// ReadKnockCompletions 返回完成了全部敲门序列的地址
+func ReadKnockCompletions() ([]string, error) {
+	return readRecentList(knockDoneList)
+}
+
+// ReadKnockProgress 返回已开始但尚未完成敲门序列的地址
+func ReadKnockProgress() ([]string, error) {
+	knockMu.Lock()
+	steps := knockSteps
+	knockMu.Unlock()
+
+	seen := make(map[string]bool)
+	var ips []string
+	for i := 1; i < steps; i++ {
+		list, err := readRecentList(knockListName(i))
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range list {
+			if !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips, nil
+}
+
+// ClearKnock 从所有敲门列表中移除该地址，使其重新从第一步开始
+func ClearKnock(ip string) {
+	knockMu.Lock()
+	steps := knockSteps
+	knockMu.Unlock()
+
+	lists := []string{knockDoneList}
+	for i := 1; i < steps; i++ {
+		lists = append(lists, knockListName(i))
+	}
+	for _, list := range lists {
+		// 地址不在列表中时写入会失败，可以忽略
+		os.WriteFile(xtRecentDir+list, []byte("-"+ip+"\n"), 0600)
+	}
+}
+
+// readRecentList 读取 /proc/net/xt_recent 中的列表，每行形如
+// src=1.2.3.4 ttl: 52 last_seen: 4295 oldest_pkt: 1 4295
+// 列表不存在（未启用敲门）时返回空
+func readRecentList(name string) ([]string, error) {
+	f, err := os.Open(xtRecentDir + name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ips []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "src=") {
+			ips = append(ips, strings.TrimPrefix(fields[0], "src="))
+		}
+	}
+	return ips, scanner.Err()
+}
@@ -0,0 +1,137 @@
+package iptables
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ICMP策略
+const (
+	ICMPAllow = "allow" // 允许全部ICMP
+	ICMPPing  = "ping"  // 只允许ping（echo-request/echo-reply）
+	ICMPDeny  = "deny"  // 不放行ICMP（ESTABLISHED,RELATED中的错误报文除外）
+)
+
+// OutboundRule 描述一条允许的出站流量，Destination 为空表示任意目的地址
+type OutboundRule struct {
+	Destination string   `json:"destination"`
+	Ports       []string `json:"ports"`
+}
+
+// BasePolicy 是防火墙的基础规则集，在白名单规则之外始终生效
+type BasePolicy struct {
+	// PublicPorts 对所有来源开放的入站端口，如 ["tcp/8888"]
+	PublicPorts []string `json:"public_ports"`
+	// Outbound 允许的出站目的地址和端口
+	Outbound []OutboundRule `json:"outbound"`
+	ICMP     string         `json:"icmp"`
+	// AllowLoopback 是否放行lo接口上的全部流量
+	AllowLoopback bool `json:"allow_loopback"`
+	// FlushConnectionsOnRevoke 撤销或过期白名单条目时用conntrack断开该地址已建立的连接，需要安装conntrack工具
+	FlushConnectionsOnRevoke bool `json:"flush_connections_on_revoke"`
+}
+
+// DefaultBasePolicy 返回未提供配置文件时使用的基础规则：
+// 只开放8888管理端口，出站只允许DNS，22端口通过白名单放行
+func DefaultBasePolicy() *BasePolicy {
+	return &BasePolicy{
+		PublicPorts: []string{"tcp/8888"},
+		Outbound: []OutboundRule{
+			{Ports: []string{"udp/53", "tcp/53"}},
+		},
+		ICMP:          ICMPDeny,
+		AllowLoopback: true,
+	}
+}
+
+// Validate 检查端口、地址和ICMP策略是否合法
+func (p *BasePolicy) Validate() error {
+	if _, err := ParsePorts(strings.Join(p.PublicPorts, ",")); err != nil {
+		return fmt.Errorf("public_ports: %v", err)
+	}
+
+	for i, rule := range p.Outbound {
+		if rule.Destination != "" && !isValidDestination(rule.Destination) {
+			return fmt.Errorf("outbound[%d]: invalid destination %q", i, rule.Destination)
+		}
+		if _, err := ParsePorts(strings.Join(rule.Ports, ",")); err != nil {
+			return fmt.Errorf("outbound[%d]: %v", i, err)
+		}
+	}
+
+	switch p.ICMP {
+	case ICMPAllow, ICMPPing, ICMPDeny:
+	default:
+		return fmt.Errorf("icmp: unknown policy %q", p.ICMP)
+	}
+	return nil
+}
+
+func isValidDestination(s string) bool {
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
+
+// inputRules 返回INPUT链的基础规则（不含ESTABLISHED,RELATED）
+func (p *BasePolicy) inputRules() [][]string {
+	var rules [][]string
+	if p.AllowLoopback {
+		rules = append(rules, []string{"-i", "lo", "-j", "ACCEPT"})
+	}
+
+	ports, _ := ParsePorts(strings.Join(p.PublicPorts, ","))
+	for _, port := range ports {
+		rules = append(rules, []string{"-p", port.Protocol, "--dport", port.Port, "-j", "ACCEPT"})
+	}
+
+	switch p.ICMP {
+	case ICMPAllow:
+		rules = append(rules, []string{"-p", "icmp", "-j", "ACCEPT"})
+	case ICMPPing:
+		rules = append(rules, []string{"-p", "icmp", "--icmp-type", "echo-request", "-j", "ACCEPT"})
+	}
+	return rules
+}
+
+// outputRules 返回OUTPUT链的基础规则（不含ESTABLISHED,RELATED）
+func (p *BasePolicy) outputRules() [][]string {
+	var rules [][]string
+	if p.AllowLoopback {
+		rules = append(rules, []string{"-o", "lo", "-j", "ACCEPT"})
+	}
+
+	for _, out := range p.Outbound {
+		var dest []string
+		if out.Destination != "" {
+			dest = []string{"-d", out.Destination}
+		}
+
+		ports, _ := ParsePorts(strings.Join(out.Ports, ","))
+		if len(ports) == 0 {
+			rules = append(rules, append(dest, "-j", "ACCEPT"))
+			continue
+		}
+		for _, port := range ports {
+			rule := append(append([]string{}, dest...), "-p", port.Protocol, "--dport", port.Port, "-j", "ACCEPT")
+			rules = append(rules, rule)
+		}
+	}
+
+	// 允许公开端口上的服务回复客户端（conntrack不可用时仍能工作）
+	ports, _ := ParsePorts(strings.Join(p.PublicPorts, ","))
+	for _, port := range ports {
+		rules = append(rules, []string{"-p", port.Protocol, "--sport", port.Port, "-j", "ACCEPT"})
+	}
+
+	switch p.ICMP {
+	case ICMPAllow:
+		rules = append(rules, []string{"-p", "icmp", "-j", "ACCEPT"})
+	case ICMPPing:
+		rules = append(rules, []string{"-p", "icmp", "--icmp-type", "echo-reply", "-j", "ACCEPT"})
+	}
+	return rules
+}
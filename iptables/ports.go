@@ -0,0 +1,92 @@
+package iptables
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRule 表示一个允许访问的服务，例如 tcp/22 或 udp/51820
+type PortRule struct {
+	Protocol string
+	// Port 为单个端口或 "起始:结束" 形式的端口范围
+	Port string
+}
+
+func (p PortRule) String() string {
+	return p.Protocol + "/" + p.Port
+}
+
+// Matches 判断协议和端口是否落在该规则内
+func (p PortRule) Matches(protocol string, port int) bool {
+	if p.Protocol != protocol {
+		return false
+	}
+	bounds := strings.SplitN(p.Port, ":", 2)
+	low, _ := strconv.Atoi(bounds[0])
+	high := low
+	if len(bounds) == 2 {
+		high, _ = strconv.Atoi(bounds[1])
+	}
+	return port >= low && port <= high
+}
+
+// ParsePorts 解析逗号分隔的端口列表，如 "tcp/22,tcp/3306,udp/51820"。
+// 空字符串表示不限制端口
+func ParsePorts(s string) ([]PortRule, error) {
+	var rules []PortRule
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid port %q, expected protocol/port", item)
+		}
+		if parts[0] != "tcp" && parts[0] != "udp" {
+			return nil, fmt.Errorf("invalid protocol %q in %q", parts[0], item)
+		}
+		if !isValidPortRange(parts[1]) {
+			return nil, fmt.Errorf("invalid port %q in %q", parts[1], item)
+		}
+		rules = append(rules, PortRule{Protocol: parts[0], Port: parts[1]})
+	}
+	return rules, nil
+}
+
+// FormatPorts 将端口列表还原为规范的逗号分隔形式
+func FormatPorts(rules []PortRule) string {
+	items := make([]string, len(rules))
+	for i, rule := range rules {
+		items[i] = rule.String()
+	}
+	return strings.Join(items, ",")
+}
+
+// NormalizePorts 校验并规范化端口列表字符串
+func NormalizePorts(s string) (string, error) {
+	rules, err := ParsePorts(s)
+	if err != nil {
+		return "", err
+	}
+	return FormatPorts(rules), nil
+}
+
+func isValidPortRange(s string) bool {
+	bounds := strings.Split(s, ":")
+	if len(bounds) > 2 {
+		return false
+	}
+
+	prev := 0
+	for _, b := range bounds {
+		port, err := strconv.Atoi(b)
+		if err != nil || port < 1 || port > 65535 || port < prev {
+			return false
+		}
+		prev = port
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package iptables
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 受管规则的注释前缀，格式为 iptables-safe:<kind>=<id>
+const commentPrefix = "iptables-safe:"
+
+const (
+	KindWhitelist = "id"
+	KindEgress    = "egress"
+	KindDeny      = "deny"
+	KindBan       = "ban"
+	KindBlocklist = "blocklist"
+)
+
+// rulesMu 串行化所有规则修改。受管规则先列出再按参数删除，处理请求的handler与后台任务
+// 同时修改时会删除对方刚插入的规则或重复插入。导出的修改函数持有该锁，不能互相调用
+var rulesMu sync.Mutex
+
+// 受管规则所在的链
+var managedChains = []string{"INPUT", "OUTPUT", DenyChain}
+
+// ManagedRule 是一条由iptables-safe插入并带有注释标记的规则
+type ManagedRule struct {
+	Chain string
+	Kind  string
+	ID    int
+	// Args 为 -A <chain> 之后的规则参数，可直接用于 -D 删除
+	Args []string
+}
+
+func ruleComment(kind string, id int) string {
+	return fmt.Sprintf("%s%s=%d", commentPrefix, kind, id)
+}
+
+// ListManagedRules 解析 iptables -S 的输出，返回所有带有iptables-safe注释的规则
+func ListManagedRules() ([]ManagedRule, error) {
+	var rules []ManagedRule
+	for _, chain := range managedChains {
+		output, err := exec.Command("iptables", iptablesArgs("-S", chain)...).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s rules: %v: %s", chain, err, string(output))
+		}
+
+		for _, line := range strings.Split(string(output), "\n") {
+			rule, ok := parseManagedRule(line)
+			if ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+func parseManagedRule(line string) (ManagedRule, bool) {
+	fields := splitRuleLine(line)
+	if len(fields) < 3 || fields[0] != "-A" {
+		return ManagedRule{}, false
+	}
+
+	for i := 2; i < len(fields)-1; i++ {
+		if fields[i] != "--comment" || !strings.HasPrefix(fields[i+1], commentPrefix) {
+			continue
+		}
+		kind, id, ok := parseComment(fields[i+1])
+		if !ok {
+			return ManagedRule{}, false
+		}
+		return ManagedRule{
+			Chain: fields[1],
+			Kind:  kind,
+			ID:    id,
+			Args:  fields[2:],
+		}, true
+	}
+	return ManagedRule{}, false
+}
+
+// parseComment 解析 iptables-safe:<kind>=<id> 形式的规则注释
+func parseComment(comment string) (string, int, bool) {
+	tag := strings.TrimPrefix(comment, commentPrefix)
+	eq := strings.Index(tag, "=")
+	if eq <= 0 {
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(tag[eq+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return tag[:eq], id, true
+}
+
+// splitRuleLine 按空白切分 iptables -S 的一行，保留双引号内的内容
+func splitRuleLine(line string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuote := false
+	hasField := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && inQuote && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case c == '"':
+			inQuote = !inQuote
+			hasField = true
+		case (c == ' ' || c == '\t') && !inQuote:
+			if hasField {
+				fields = append(fields, current.String())
+				current.Reset()
+				hasField = false
+			}
+		default:
+			current.WriteByte(c)
+			hasField = true
+		}
+	}
+	if hasField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+func deleteRule(rule ManagedRule) error {
+	args := append([]string{"iptables", "-D", rule.Chain}, rule.Args...)
+	return runCommand(args...)
+}
+
+// removeManagedRules 删除指定类型和ID的全部受管规则，返回被删除的规则
+func removeManagedRules(kind string, id int) ([]ManagedRule, error) {
+	rules, err := ListManagedRules()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []ManagedRule
+	for _, rule := range rules {
+		if rule.Kind != kind || rule.ID != id {
+			continue
+		}
+		if err := deleteRule(rule); err != nil {
+			return removed, err
+		}
+		removed = append(removed, rule)
+	}
+	return removed, nil
+}
+
+// pruneManagedRules 删除该类型中ID不在 active 内的规则，
+// 返回仍然存在规则的ID集合以及被删除的规则
+func pruneManagedRules(kind string, active map[int]bool) (map[int]bool, []ManagedRule, error) {
+	rules, err := ListManagedRules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied := make(map[int]bool)
+	var removed []ManagedRule
+	for _, rule := range rules {
+		if rule.Kind != kind {
+			continue
+		}
+		if !active[rule.ID] {
+			if err := deleteRule(rule); err != nil {
+				log.Printf("Failed to remove stale %s rule %d: %v", kind, rule.ID, err)
+				continue
+			}
+			removed = append(removed, rule)
+			continue
+		}
+		applied[rule.ID] = true
+	}
+	return applied, removed, nil
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
+	"bufio"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"iptables-safe/access"
+	"iptables-safe/blocklist"
+	"iptables-safe/config"
 	"iptables-safe/database"
+	"iptables-safe/dnsknock"
+	"iptables-safe/geoip"
 	"iptables-safe/handlers"
 	"iptables-safe/iptables"
+	"iptables-safe/notify"
+	"iptables-safe/resolver"
+	"iptables-safe/spa"
+	"iptables-safe/sshknock"
 )
 
 func main() {
+	setApprover := flag.Bool("set-approver-password", false, "从标准输入读取新的审批人密码，保存后退出")
+	flag.Parse()
+
 	log.Println("Starting iptables-safe application...")
 
 	if err := database.InitDB("./iptables-safe.db"); err != nil {
@@ -18,17 +36,91 @@ func main() {
 	}
 	defer database.DB.Close()
 
-	if err := iptables.InitializeFirewall(); err != nil {
+	if *setApprover {
+		if err := setApproverPassword(); err != nil {
+			log.Fatalf("Failed to set approver password: %v", err)
+		}
+		log.Println("Approver password updated")
+		return
+	}
+
+	cfg, err := config.Load("./firewall.json")
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	iptables.SetResolver(resolver.New(cfg.DNSResolver))
+
+	if cfg.GeoIPDatabase != "" {
+		if err := geoip.Load(cfg.GeoIPDatabase); err != nil {
+			log.Printf("Warning: Failed to load GeoIP database %s: %v", cfg.GeoIPDatabase, err)
+		} else {
+			log.Printf("Loaded GeoIP database %s", cfg.GeoIPDatabase)
+		}
+	}
+
+	notify.SetWebhook(cfg.NotifyWebhook)
+
+	if err := iptables.InitializeFirewall(cfg.FirewallPolicy()); err != nil {
 		log.Fatalf("Failed to initialize firewall: %v", err)
 	}
 
+	if err := blocklist.Reconcile(); err != nil {
+		log.Printf("Warning: Failed to restore blocklists: %v", err)
+	}
+
 	go cleanupWorker()
+	go whitelistExpiryWorker()
+	go egressRefreshWorker()
+	go whitelistHostWorker()
+	go trafficWorker()
+	go banExpiryWorker()
+	go blocklistWorker()
+	go knockWorker()
+	go approvalWorker()
+
+	if cfg.SPAPort > 0 {
+		go func() {
+			if err := spa.ListenAndServe(cfg.SPAPort); err != nil {
+				log.Printf("SPA listener stopped: %v", err)
+			}
+		}()
+	}
+
+	if cfg.SSHKnockPort > 0 {
+		go func() {
+			if err := sshknock.ListenAndServe(cfg.SSHKnockPort, cfg.SSHHostKey); err != nil {
+				log.Printf("SSH knock server stopped: %v", err)
+			}
+		}()
+	}
+
+	if cfg.DNSKnockPort > 0 {
+		go func() {
+			if err := dnsknock.ListenAndServe(cfg.DNSKnockPort, cfg.DNSKnockZone); err != nil {
+				log.Printf("DNS knock server stopped: %v", err)
+			}
+		}()
+	}
 
 	router := gin.Default()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Failed to set trusted proxies: %v", err)
+	}
 	router.LoadHTMLGlob("templates/*")
 
 	router.GET("/", handlers.UserLoginPage)
 	router.POST("/api/login", handlers.UserLogin)
+	router.GET("/api/services", handlers.GetPublicServices)
+	router.GET("/status", handlers.UserStatusPage)
+	router.GET("/api/status", handlers.GetUserStatus)
+	router.POST("/api/status/:id/extend", handlers.ExtendUserEntry)
+	router.DELETE("/api/status/:id", handlers.RevokeUserEntry)
+	router.GET("/link/:token", handlers.AccessLinkPage)
+	router.GET("/api/link/:token", handlers.GetAccessLinkInfo)
+	router.POST("/api/link/:token", handlers.RedeemAccessLink)
+	router.POST("/api/access-requests", handlers.SubmitAccessRequest)
+	router.GET("/api/access-requests/:token", handlers.GetAccessRequestStatus)
 
 	router.GET("/admin", handlers.AdminLoginPage)
 	router.POST("/api/admin/login", handlers.AdminLogin)
@@ -44,9 +136,71 @@ func main() {
 	{
 		api.GET("/whitelist", handlers.GetWhitelistIPs)
 		api.POST("/whitelist", handlers.AddWhitelistIP)
+		api.PUT("/whitelist/:id", handlers.UpdateWhitelistIP)
 		api.DELETE("/whitelist/:id", handlers.DeleteWhitelistIP)
+		api.GET("/whitelist/:id/history", handlers.GetWhitelistHistory)
+		api.POST("/whitelist/:id/disconnect", handlers.DisconnectWhitelistIP)
+		api.GET("/whitelist/:id/traffic", handlers.GetWhitelistTraffic)
+		api.GET("/connections", handlers.GetConnections)
+		api.GET("/reviews", handlers.GetReviews)
+		api.POST("/reviews/:id", handlers.ReviewWhitelistIP)
+		api.GET("/reviews/decisions", handlers.GetReviewDecisions)
+		api.GET("/services", handlers.GetServices)
+		api.POST("/services", handlers.AddService)
+		api.PUT("/services/:id", handlers.UpdateService)
+		api.DELETE("/services/:id", handlers.DeleteService)
+		api.GET("/deny", handlers.GetDenyEntries)
+		api.POST("/deny", handlers.AddDenyEntry)
+		api.DELETE("/deny/:id", handlers.DeleteDenyEntry)
+		api.GET("/blocklists", handlers.GetBlocklists)
+		api.POST("/blocklists", handlers.AddBlocklist)
+		api.PUT("/blocklists/:id", handlers.UpdateBlocklist)
+		api.DELETE("/blocklists/:id", handlers.DeleteBlocklist)
+		api.POST("/blocklists/:id/refresh", handlers.RefreshBlocklist)
+		api.GET("/bans", handlers.GetBans)
+		api.DELETE("/bans/:id", handlers.UnbanIP)
+		api.GET("/egress", handlers.GetEgressRules)
+		api.POST("/egress", handlers.AddEgressRule)
+		api.DELETE("/egress/:id", handlers.DeleteEgressRule)
+		api.GET("/policy/user", handlers.GetUserPolicy)
+		api.PUT("/policy/user", handlers.UpdateUserPolicy)
+		api.GET("/policy/review", handlers.GetReviewPolicy)
+		api.PUT("/policy/review", handlers.UpdateReviewPolicy)
+		api.GET("/policy/ban", handlers.GetBanPolicy)
+		api.PUT("/policy/ban", handlers.UpdateBanPolicy)
+		api.GET("/policy/country", handlers.GetCountryPolicy)
+		api.PUT("/policy/country", handlers.UpdateCountryPolicy)
+		api.GET("/login-attempts", handlers.GetLoginAttempts)
+		api.GET("/knock", handlers.GetKnockSequence)
+		api.PUT("/knock", handlers.UpdateKnockSequence)
+		api.POST("/knock/rotate", handlers.RotateKnockSequence)
+		api.GET("/spa", handlers.GetSPASettings)
+		api.POST("/spa/rotate", handlers.RotateSPAKey)
+		api.GET("/users", handlers.GetUsers)
+		api.POST("/users", handlers.AddUser)
+		api.DELETE("/users/:id", handlers.DeleteUser)
+		api.PUT("/users/:id/services", handlers.UpdateUserServices)
+		api.POST("/users/:id/keys", handlers.AddSSHKey)
+		api.DELETE("/ssh-keys/:id", handlers.DeleteSSHKey)
+		api.GET("/ssh", handlers.GetSSHKnockSettings)
+		api.POST("/users/:id/dns-secret", handlers.RotateUserDNSSecret)
+		api.DELETE("/users/:id/dns-secret", handlers.DisableUserDNSSecret)
+		api.GET("/dns", handlers.GetDNSKnockSettings)
+		api.GET("/links", handlers.GetAccessLinks)
+		api.POST("/links", handlers.CreateAccessLink)
+		api.DELETE("/links/:id", handlers.RevokeAccessLink)
+		api.GET("/links/:id/uses", handlers.GetAccessLinkUses)
+		api.GET("/access-requests", handlers.GetAccessRequests)
+		api.POST("/access-requests/:id/approve", handlers.ApproveAccessRequest)
+		api.POST("/access-requests/:id/deny", handlers.DenyAccessRequest)
+		api.GET("/pending", handlers.GetPendingChanges)
+		api.POST("/pending/:id/approve", handlers.ApprovePendingChange)
+		api.POST("/pending/:id/reject", handlers.RejectPendingChange)
+		api.GET("/policy/approval", handlers.GetApprovalPolicy)
+		api.PUT("/policy/approval", handlers.UpdateApprovalPolicy)
 		api.PUT("/password/user", handlers.UpdateUserPassword)
 		api.PUT("/password/admin", handlers.UpdateAdminPassword)
+		api.PUT("/password/approver", handlers.UpdateApproverPassword)
 	}
 
 	log.Println("Server starting on :8888")
@@ -55,6 +209,23 @@ func main() {
 	}
 }
 
+// whitelistExpiryWorker 每分钟删除已过期的白名单条目及其规则，使较短的授权能按时失效。
+// 对账同时补齐缺失的规则
+func whitelistExpiryWorker() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := database.CleanupExpiredIPs(); err != nil {
+			log.Printf("Error cleaning up expired IPs: %v", err)
+		}
+
+		if err := iptables.ReconcileWhitelist(); err != nil {
+			log.Printf("Error reconciling whitelist rules: %v", err)
+		}
+	}
+}
+
 func cleanupWorker() {
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
@@ -62,12 +233,154 @@ func cleanupWorker() {
 	for range ticker.C {
 		log.Println("Running cleanup tasks...")
 		
-		if err := database.CleanupExpiredIPs(); err != nil {
-			log.Printf("Error cleaning up expired IPs: %v", err)
+		if err := database.CleanupExpiredDenyEntries(); err != nil {
+			log.Printf("Error cleaning up expired deny entries: %v", err)
+		}
+
+		if err := iptables.ReconcileDeny(); err != nil {
+			log.Printf("Error reconciling deny rules: %v", err)
+		}
+
+		if err := blocklist.Reconcile(); err != nil {
+			log.Printf("Error reconciling blocklist rules: %v", err)
+		}
+
+		if err := database.CleanupExpiredEgressRules(); err != nil {
+			log.Printf("Error cleaning up expired egress rules: %v", err)
+		}
+
+		if err := iptables.ReconcileEgress(); err != nil {
+			log.Printf("Error reconciling egress rules: %v", err)
 		}
 		
 		if err := database.CleanupOldLoginAttempts(); err != nil {
 			log.Printf("Error cleaning up old login attempts: %v", err)
 		}
+
+		if err := database.CleanupOldLockouts(time.Now().Add(-access.BanHistoryWindow)); err != nil {
+			log.Printf("Error cleaning up old lockouts: %v", err)
+		}
+
+		if err := database.CleanupOldTrafficSamples(iptables.TrafficRetention); err != nil {
+			log.Printf("Error cleaning up old traffic samples: %v", err)
+		}
+
+		if reviews, err := access.PendingReviews(); err != nil {
+			log.Printf("Error checking permanent entries for review: %v", err)
+		} else if len(reviews) > 0 {
+			log.Printf("%d permanent whitelist entries awaiting review", len(reviews))
+		}
+	}
+}
+
+// egressRefreshWorker 定期重新解析TTL已到期的域名出站条目
+func egressRefreshWorker() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := iptables.RefreshEgressHosts(); err != nil {
+			log.Printf("Error refreshing egress hostnames: %v", err)
+		}
+	}
+}
+
+// whitelistHostWorker 定期重新解析动态DNS白名单条目，跟随家庭宽带等变化的地址
+func whitelistHostWorker() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := iptables.RefreshWhitelistHosts(); err != nil {
+			log.Printf("Error refreshing whitelist hostnames: %v", err)
+		}
+	}
+}
+
+// trafficWorker 每分钟采集一次白名单规则的流量计数
+func trafficWorker() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := iptables.CollectTraffic(); err != nil {
+			log.Printf("Error collecting traffic counters: %v", err)
+		}
+	}
+}
+
+// banExpiryWorker 每分钟删除已到期的自动封禁规则，使较短的封禁能按时解除
+func banExpiryWorker() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := iptables.ReconcileBans(); err != nil {
+			log.Printf("Error reconciling bans: %v", err)
+		}
+	}
+}
+
+// blocklistWorker 每分钟检查一次，重新导入已到刷新时间的威胁情报列表
+func blocklistWorker() {
+	if err := blocklist.RefreshDue(); err != nil {
+		log.Printf("Error refreshing blocklists: %v", err)
+	}
+
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := blocklist.RefreshDue(); err != nil {
+			log.Printf("Error refreshing blocklists: %v", err)
+		}
+	}
+}
+
+// knockWorker 每秒读取一次端口敲门进度，为完成序列的地址解锁
+func knockWorker() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := access.ProcessKnocks(); err != nil {
+			log.Printf("Error processing knocks: %v", err)
+		}
+	}
+}
+
+// approvalWorker 每分钟检查一次，使超时未处理的访问申请和待审批变更过期
+func approvalWorker() {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := access.ExpireRequests(); err != nil {
+			log.Printf("Error expiring access requests: %v", err)
+		}
+		if err := access.ExpireChanges(); err != nil {
+			log.Printf("Error expiring pending changes: %v", err)
+		}
+	}
+}
+
+// setApproverPassword 在服务器上设置审批人密码。审批人密码不能通过管理后台首次设置，
+// 否则持有管理员会话的人可以自己提交并批准变更
+func setApproverPassword() error {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return err
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return errors.New("password is empty")
+	}
+	config, err := database.GetConfig()
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(config.AdminPassword), []byte(password)) == nil {
+		return errors.New("approver password must differ from the admin password")
 	}
+	return database.UpdateApproverPassword(password)
 }
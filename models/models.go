@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -11,16 +12,284 @@ type WhitelistIP struct {
 	IsPermanent bool      `json:"is_permanent"`
 	CreatedAt   time.Time `json:"created_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
+	Ports       string    `json:"ports"`
+	ServiceID   int       `json:"service_id"`
+	ServiceName string    `json:"service_name"`
+	// Hostname 非空时该条目跟随域名解析结果（动态DNS），IP为当前解析到的地址
+	Hostname string `json:"hostname"`
+	// LastActiveAt 为最近一次采样到该条目有入站流量的时间
+	LastActiveAt *time.Time `json:"last_active_at"`
+	// LastReviewedAt 为永久条目最近一次通过复核的时间
+	LastReviewedAt *time.Time `json:"last_reviewed_at"`
+	// Country 为添加或地址变化时IP所属国家的ISO代码，未配置GeoIP数据库时为空
+	Country string `json:"country"`
+	// UserID 为通过SSH密钥或DNS解锁获得该条目的用户，0表示未识别用户
+	UserID   int    `json:"user_id"`
+	UserName string `json:"user_name"`
+	// GrantedAt 为最近一次授权（新增或重新登录）的时间，旧条目为创建时间
+	GrantedAt time.Time `json:"granted_at"`
+	// Source 为最近一次授权的解锁方式（password、knock、link 等），管理员添加的条目为空
+	Source string `json:"source"`
+	// Traffic 为保留期内采样到的流量合计
+	Traffic TrafficStats `json:"traffic"`
+}
+
+// TrafficStats 是白名单规则的包数和字节数，In 为来自该IP的流量，Out 为发往该IP的流量
+type TrafficStats struct {
+	PacketsIn  int64 `json:"packets_in"`
+	BytesIn    int64 `json:"bytes_in"`
+	PacketsOut int64 `json:"packets_out"`
+	BytesOut   int64 `json:"bytes_out"`
+}
+
+// TrafficSample 是一次采样周期内白名单条目的流量增量
+type TrafficSample struct {
+	TrafficStats
+	SampledAt time.Time `json:"sampled_at"`
+}
+
+// WhitelistHistory 是白名单条目的一条变更记录
+type WhitelistHistory struct {
+	ID        int       `json:"id"`
+	EntryID   int       `json:"entry_id"`
+	Action    string    `json:"action"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Config struct {
 	ID            int    `json:"id"`
 	UserPassword  string `json:"user_password"`
 	AdminPassword string `json:"admin_password"`
+	UserPorts     string `json:"user_ports"`
+	// StaleAfterDays 永久条目连续多少天没有流量后需要复核，0表示不检查
+	StaleAfterDays int `json:"stale_after_days"`
+	// ReviewIntervalDays 永久条目每隔多少天需要重新复核，0表示不检查
+	ReviewIntervalDays int `json:"review_interval_days"`
+	// BanAfterLockouts 在 BanWindowMinutes 内被锁定多少次后封禁来源IP，0表示不自动封禁
+	BanAfterLockouts int `json:"ban_after_lockouts"`
+	BanWindowMinutes int `json:"ban_window_minutes"`
+	// BanBaseMinutes 为第一次封禁的时长，之后每次翻倍，最长 BanMaxMinutes
+	BanBaseMinutes int `json:"ban_base_minutes"`
+	BanMaxMinutes  int `json:"ban_max_minutes"`
+	// CountryMode 为 allow（只允许 Countries 中的国家）、deny（拒绝 Countries 中的国家）或空（不限制）
+	CountryMode string `json:"country_mode"`
+	// Countries 为逗号分隔的国家ISO代码，如 "CN,HK"
+	Countries string `json:"countries"`
+	// KnockSequence 为端口敲门序列，如 "tcp/7000,udp/8123,tcp/9001"，为空表示关闭
+	KnockSequence string `json:"knock_sequence"`
+	// SPAKey 为单包授权的共享密钥（64位十六进制），为空时不接受SPA请求
+	SPAKey string `json:"-"`
+	// LinkKey 为签名访问链接的密钥（64位十六进制），首次创建链接时生成
+	LinkKey string `json:"-"`
+	// FourEyesPermanent 为真时添加永久条目需要第二位管理员批准
+	FourEyesPermanent bool `json:"four_eyes_permanent"`
+	// ApproverPassword 为审批人密码的哈希，与管理员密码分开保存，为空表示尚未设置
+	ApproverPassword string `json:"-"`
+	// PendingTTLHours 为待审批变更的有效期，超时未批准的变更自动过期
+	PendingTTLHours int `json:"pending_ttl_hours"`
+	// FourEyesMaxHours 为开启双人审批时管理员可直接添加的临时条目的最长有效期，
+	// 超过时与永久条目一样需要批准
+	FourEyesMaxHours int `json:"four_eyes_max_hours"`
+	// FourEyesMinPrefix 为开启双人审批时无需批准的最短网段前缀长度，比它更宽的网段需要批准，0 表示不按网段限制
+	FourEyesMinPrefix int `json:"four_eyes_min_prefix"`
+	// UserMaxDurationMinutes 为默认登录的最长授权时长，用户登录时可以选择更短的时长
+	UserMaxDurationMinutes int `json:"user_max_duration_minutes"`
+	// MaxIPsPerUser 为每个已识别用户同时放行的IP数，超过时撤销最早授权的IP，0表示不限制
+	MaxIPsPerUser int `json:"max_ips_per_user"`
 }
 
-type LoginAttempt struct {
+// ReviewDecision 是管理员对一个待复核条目做出的决定
+type ReviewDecision struct {
+	ID          int    `json:"id"`
+	EntryID     int    `json:"entry_id"`
+	IP          string `json:"ip"`
+	Description string `json:"description"`
+	// Decision 为 recertify（保留）或 remove（删除）
+	Decision  string    `json:"decision"`
+	Reasons   string    `json:"reasons"`
+	Note      string    `json:"note"`
+	AdminIP   string    `json:"admin_ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Service 是一个受保护的服务，拥有独立的端口、密码和访问策略
+type Service struct {
+	ID                 int       `json:"id"`
+	Name               string    `json:"name"`
+	Ports              string    `json:"ports"`
+	Password           string    `json:"-"`
+	MaxDurationMinutes int       `json:"max_duration_minutes"`
+	MaxFailedAttempts  int       `json:"max_failed_attempts"`
+	LockoutMinutes     int       `json:"lockout_minutes"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// EgressRule 是一条受管的出站放行条目，ExpiresAt 为空表示长期有效
+type EgressRule struct {
+	ID          int        `json:"id"`
+	Destination string     `json:"destination"`
+	Ports       string     `json:"ports"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	// Addresses 为域名条目当前解析到的地址
+	Addresses []EgressAddress `json:"addresses,omitempty"`
+}
+
+// EgressAddress 是域名出站条目的一个解析结果，按DNS TTL过期
+type EgressAddress struct {
+	Address    string    `json:"address"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	ResolvedAt time.Time `json:"resolved_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// DenyEntry 是一条黑名单条目，ExpiresAt 为空表示长期有效
+type DenyEntry struct {
+	ID        int        `json:"id"`
+	Address   string     `json:"address"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// Ban 是一次因反复登录失败而自动添加的防火墙封禁，Level 从0开始，每级时长翻倍
+type Ban struct {
+	ID        int       `json:"id"`
 	IP        string    `json:"ip"`
-	Timestamp time.Time `json:"timestamp"`
-	Success   bool      `json:"success"`
+	Level     int       `json:"level"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// LiftedAt 为管理员手动解封的时间
+	LiftedAt *time.Time `json:"lifted_at"`
+}
+
+// BlocklistSource 是一个定期导入到ipset的威胁情报列表，Location 为本地路径或HTTP(S) URL
+type BlocklistSource struct {
+	ID             int    `json:"id"`
+	Name           string `json:"name"`
+	Format         string `json:"format"`
+	Location       string `json:"location"`
+	RefreshMinutes int    `json:"refresh_minutes"`
+	Enabled        bool   `json:"enabled"`
+	// EntryCount 为最近一次成功导入的网段数
+	EntryCount    int        `json:"entry_count"`
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	LastUpdatedAt *time.Time `json:"last_updated_at"`
+	// LastError 为最近一次导入失败的原因，成功后清空
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// User 是可以用自己的凭据（SSH密钥、DNS解锁密钥）解锁的工程师
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Keys      []SSHKey  `json:"keys"`
+	// DNSSecret 为DNS解锁的密钥（十六进制），不通过API返回
+	DNSSecret string `json:"-"`
+	// DNSKnock 表示是否已为该用户启用DNS解锁
+	DNSKnock bool `json:"dns_knock"`
+	// ServiceIDs 为用户可以通过SSH或DNS解锁的服务，0 表示默认登录
+	ServiceIDs []int `json:"service_ids"`
+}
+
+// SSHKey 是用户的一把SSH公钥，PublicKey 为 authorized_keys 格式
+type SSHKey struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	UserName    string     `json:"user_name"`
+	PublicKey   string     `json:"public_key"`
+	Fingerprint string     `json:"fingerprint"`
+	Comment     string     `json:"comment"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+// AccessLink 是管理员生成的预签名访问链接，打开后无需密码即可按所选服务解锁访问者的IP
+type AccessLink struct {
+	ID          int    `json:"id"`
+	Description string `json:"description"`
+	// ServiceIDs 为允许解锁的服务，0 表示默认登录
+	ServiceIDs      []int      `json:"service_ids"`
+	MaxUses         int        `json:"max_uses"`
+	Uses            int        `json:"uses"`
+	DurationMinutes int        `json:"duration_minutes"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	// URL 为完整的访问链接，由签名计算，不保存在数据库中
+	URL string `json:"url,omitempty"`
+}
+
+// AccessLinkUse 是访问链接的一次使用记录，包括被拒绝的尝试
+type AccessLinkUse struct {
+	ID          int       `json:"id"`
+	LinkID      int       `json:"link_id"`
+	IP          string    `json:"ip"`
+	ServiceID   int       `json:"service_id"`
+	ServiceName string    `json:"service_name"`
+	Country     string    `json:"country"`
+	Success     bool      `json:"success"`
+	Detail      string    `json:"detail"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AccessRequest 是用户在登录页提交的访问申请，管理员批准后解锁申请时的IP
+type AccessRequest struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Reason      string `json:"reason"`
+	IP          string `json:"ip"`
+	Country     string `json:"country"`
+	ServiceID   int    `json:"service_id"`
+	ServiceName string `json:"service_name"`
+	// DurationMinutes 为申请的授权时长，批准时管理员可以缩短
+	DurationMinutes int `json:"duration_minutes"`
+	// Status 为 pending、approved、denied 或 expired
+	Status string `json:"status"`
+	// Note 为管理员做决定时的备注，会显示给申请人
+	Note string `json:"note"`
+	// Token 为申请人查询结果使用的随机值，不通过管理接口返回
+	Token     string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at"`
+	// ExpiresAt 为批准后白名单的过期时间
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// PendingChange 是等待第二位管理员批准的变更
+type PendingChange struct {
+	ID int `json:"id"`
+	// Kind 为变更类型，目前只有 whitelist_add
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	// Payload 为批准后执行变更所需的数据，whitelist_add 为要添加的白名单条目
+	Payload json.RawMessage `json:"payload"`
+	// Status 为 pending、approved、rejected 或 expired
+	Status string `json:"status"`
+	// RequestedBy 为提交变更的管理员IP
+	RequestedBy string `json:"requested_by"`
+	// RequestedSession 为提交者管理员会话的哈希，同一会话不能批准自己的变更
+	RequestedSession string     `json:"-"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	DecidedAt        *time.Time `json:"decided_at"`
+	// DecidedBy 为批准人填写的姓名，拒绝时为拒绝者的IP
+	DecidedBy string `json:"decided_by"`
+	Note      string `json:"note"`
+}
+
+type LoginAttempt struct {
+	IP          string    `json:"ip"`
+	Timestamp   time.Time `json:"timestamp"`
+	Success     bool      `json:"success"`
+	ServiceID   int       `json:"service_id"`
+	ServiceName string    `json:"service_name"`
+	// Method 为解锁方式：password（网页登录）、knock（端口敲门）、spa（单包授权）、ssh（SSH密钥）、dns（DNS解锁）或 link（访问链接）
+	Method  string `json:"method"`
+	Country string `json:"country"`
 }
@@ -0,0 +1,61 @@
+// Package notify 把事件以JSON POST到 firewall.json 中配置的 notify_webhook，
+// 消息带有 text 字段，可以直接使用 Slack、Mattermost 等的传入Webhook
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	mu         sync.Mutex
+	webhookURL string
+	client     = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Event 是发送到Webhook的消息
+type Event struct {
+	Event string      `json:"event"`
+	Text  string      `json:"text"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// SetWebhook 设置接收通知的URL，为空表示不发送
+func SetWebhook(url string) {
+	mu.Lock()
+	defer mu.Unlock()
+	webhookURL = url
+}
+
+// Send 在后台发送一条通知，失败只记录日志
+func Send(event, text string, data interface{}) {
+	mu.Lock()
+	url := webhookURL
+	mu.Unlock()
+	if url == "" {
+		return
+	}
+
+	body, err := json.Marshal(Event{Event: event, Text: text, Time: time.Now(), Data: data})
+	if err != nil {
+		log.Printf("Error encoding notification %s: %v", event, err)
+		return
+	}
+
+	go func() {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Error sending notification %s: %v", event, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Notification %s rejected by webhook: %s", event, resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,160 @@
+package resolver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Record 是一条A记录及其TTL
+type Record struct {
+	IP  string
+	TTL time.Duration
+}
+
+// Resolver 向指定的DNS服务器查询A记录并返回TTL。
+// 标准库的 net.Resolver 不提供TTL，因此这里直接构造DNS报文
+type Resolver struct {
+	// Server 为 host:port 形式的DNS服务器地址，可以指向本地的测试桩
+	Server  string
+	Timeout time.Duration
+}
+
+// New 创建解析器，server 为空时使用 /etc/resolv.conf 中的第一个nameserver
+func New(server string) *Resolver {
+	if server == "" {
+		server = systemNameserver()
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &Resolver{Server: server, Timeout: defaultTimeout}
+}
+
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// LookupA 查询域名的A记录，应答被截断时改用TCP重试
+func (r *Resolver) LookupA(host string) ([]Record, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname %q: %v", host, err)
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.exchange("udp", packed, query.Header.ID)
+	if err == nil && resp.Header.Truncated {
+		resp, err = r.exchange("tcp", packed, query.Header.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s for %s: %v", r.Server, host, err)
+	}
+
+	if resp.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("query for %s failed: %v", host, resp.Header.RCode)
+	}
+
+	var records []Record
+	for _, answer := range resp.Answers {
+		a, ok := answer.Body.(*dnsmessage.AResource)
+		if !ok {
+			continue
+		}
+		records = append(records, Record{
+			IP:  net.IP(a.A[:]).String(),
+			TTL: time.Duration(answer.Header.TTL) * time.Second,
+		})
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no A record found for %s", host)
+	}
+	return records, nil
+}
+
+func (r *Resolver) exchange(network string, packed []byte, id uint16) (*dnsmessage.Message, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	conn, err := net.DialTimeout(network, r.Server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var buf []byte
+	if network == "tcp" {
+		// TCP报文前有两字节长度
+		out := make([]byte, 2+len(packed))
+		binary.BigEndian.PutUint16(out, uint16(len(packed)))
+		copy(out[2:], packed)
+		if _, err := conn.Write(out); err != nil {
+			return nil, err
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 4096)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(buf); err != nil {
+		return nil, err
+	}
+	if resp.Header.ID != id || !resp.Header.Response {
+		return nil, errors.New("unexpected DNS response")
+	}
+	return &resp, nil
+}
@@ -0,0 +1,154 @@
+package resolver
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubAnswer 描述测试桩对某个域名的应答
+type stubAnswer struct {
+	rcode dnsmessage.RCode
+	cname string
+	addrs []string
+	ttl   uint32
+}
+
+// startStub 在本地UDP端口启动测试桩，按查询的域名返回 answers 中的应答，返回其地址
+func startStub(t *testing.T, answers map[string]stubAnswer) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			msg := stubResponse(query, answers)
+			resp, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteToUDP(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func stubResponse(query dnsmessage.Message, answers map[string]stubAnswer) dnsmessage.Message {
+	q := query.Questions[0]
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.Header.ID, Response: true, RecursionAvailable: true},
+		Questions: query.Questions,
+	}
+
+	answer, ok := answers[strings.TrimSuffix(q.Name.String(), ".")]
+	if !ok {
+		resp.Header.RCode = dnsmessage.RCodeNameError
+		return resp
+	}
+	resp.Header.RCode = answer.rcode
+
+	name := q.Name
+	if answer.cname != "" {
+		target := dnsmessage.MustNewName(answer.cname + ".")
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: answer.ttl},
+			Body:   &dnsmessage.CNAMEResource{CNAME: target},
+		})
+		name = target
+	}
+	for _, addr := range answer.addrs {
+		var a [4]byte
+		copy(a[:], net.ParseIP(addr).To4())
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: answer.ttl},
+			Body:   &dnsmessage.AResource{A: a},
+		})
+	}
+	return resp
+}
+
+func TestLookupATTL(t *testing.T) {
+	server := startStub(t, map[string]stubAnswer{
+		"api.example.com": {addrs: []string{"192.0.2.1", "192.0.2.2"}, ttl: 300},
+	})
+	r := &Resolver{Server: server, Timeout: time.Second}
+
+	records, err := r.LookupA("api.example.com")
+	if err != nil {
+		t.Fatalf("LookupA failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	for i, want := range []string{"192.0.2.1", "192.0.2.2"} {
+		if records[i].IP != want {
+			t.Errorf("record %d is %s, want %s", i, records[i].IP, want)
+		}
+		if records[i].TTL != 300*time.Second {
+			t.Errorf("record %d has TTL %v, want 5m0s", i, records[i].TTL)
+		}
+	}
+}
+
+func TestLookupACNAME(t *testing.T) {
+	server := startStub(t, map[string]stubAnswer{
+		"www.example.com": {cname: "cdn.example.net", addrs: []string{"198.51.100.7"}, ttl: 60},
+	})
+	r := &Resolver{Server: server, Timeout: time.Second}
+
+	records, err := r.LookupA("www.example.com.")
+	if err != nil {
+		t.Fatalf("LookupA failed: %v", err)
+	}
+	if len(records) != 1 || records[0].IP != "198.51.100.7" || records[0].TTL != time.Minute {
+		t.Fatalf("got %+v, want the A record behind the CNAME", records)
+	}
+}
+
+func TestLookupACNAMEWithoutAddress(t *testing.T) {
+	server := startStub(t, map[string]stubAnswer{
+		"alias.example.com": {cname: "gone.example.net", ttl: 60},
+	})
+	r := &Resolver{Server: server, Timeout: time.Second}
+
+	if _, err := r.LookupA("alias.example.com"); err == nil {
+		t.Fatal("expected an error for a CNAME without A records")
+	}
+}
+
+func TestLookupANXDOMAIN(t *testing.T) {
+	server := startStub(t, map[string]stubAnswer{})
+	r := &Resolver{Server: server, Timeout: time.Second}
+
+	_, err := r.LookupA("missing.example.com")
+	if err == nil {
+		t.Fatal("expected an error for NXDOMAIN")
+	}
+	if !strings.Contains(err.Error(), "RCodeNameError") {
+		t.Errorf("error %q does not mention NXDOMAIN", err)
+	}
+}
+
+func TestNewAddsDefaultPort(t *testing.T) {
+	if r := New("127.0.0.1"); r.Server != "127.0.0.1:53" {
+		t.Errorf("got server %s, want 127.0.0.1:53", r.Server)
+	}
+	if r := New("127.0.0.1:5353"); r.Server != "127.0.0.1:5353" {
+		t.Errorf("got server %s, want 127.0.0.1:5353", r.Server)
+	}
+}
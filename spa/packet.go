@@ -0,0 +1,146 @@
+// Package spa 实现单包授权（Single Packet Authorization）：客户端发送一个加密并带HMAC的UDP包，
+// 服务端验证后把来源地址加入白名单，在此之前服务器不需要对外开放任何TCP端口
+package spa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 数据包格式：版本(1字节) | IV(16字节) | AES-256-CTR密文 | HMAC-SHA256(前面所有字节)
+const (
+	packetVersion = 1
+	ivSize        = aes.BlockSize
+	macSize       = sha256.Size
+	keySize       = 32
+	nonceSize     = 16
+	// MaxPacketSize 为接受的最大数据包长度
+	MaxPacketSize = 1024
+	// MaxClockSkew 为请求时间戳与服务器时间允许的最大偏差，超出的请求被视为重放
+	MaxClockSkew = 60 * time.Second
+)
+
+var (
+	ErrInvalidPacket = errors.New("invalid packet")
+	ErrBadMAC        = errors.New("HMAC verification failed")
+)
+
+// Request 是数据包中加密的内容
+type Request struct {
+	// Timestamp 为客户端发送时的Unix时间（秒）
+	Timestamp int64 `json:"ts"`
+	// Nonce 为随机值（十六进制），同一个Nonce在有效期内只能使用一次
+	Nonce string `json:"nonce"`
+	// Service 为要解锁的服务名称。SPA密钥由所有用户共享，服务器只接受空值（默认登录）
+	Service string `json:"service"`
+	// IP 必须与数据包的来源地址一致，防止截获的数据包从其他地址抢先使用
+	IP string `json:"ip,omitempty"`
+}
+
+// NewRequest 生成带当前时间和随机Nonce的请求
+func NewRequest(service, ip string) (Request, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return Request{}, err
+	}
+	return Request{
+		Timestamp: time.Now().Unix(),
+		Nonce:     hex.EncodeToString(nonce),
+		Service:   service,
+		IP:        ip,
+	}, nil
+}
+
+// GenerateKey 生成新的随机密钥，以十六进制表示
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// ParseKey 解析十六进制密钥
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d hex characters", keySize*2)
+	}
+	return key, nil
+}
+
+// deriveKeys 从共享密钥派生加密和HMAC使用的两个独立密钥
+func deriveKeys(key []byte) (encKey, macKey []byte) {
+	derive := func(label string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(label))
+		return h.Sum(nil)
+	}
+	return derive("iptables-safe spa encryption"), derive("iptables-safe spa authentication")
+}
+
+// Seal 加密请求并附加HMAC，返回可直接发送的数据包
+func Seal(key []byte, req Request) ([]byte, error) {
+	plaintext, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	encKey, macKey := deriveKeys(key)
+
+	packet := make([]byte, 1+ivSize+len(plaintext), 1+ivSize+len(plaintext)+macSize)
+	packet[0] = packetVersion
+	iv := packet[1 : 1+ivSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, iv).XORKeyStream(packet[1+ivSize:], plaintext)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(packet)
+	packet = mac.Sum(packet)
+	if len(packet) > MaxPacketSize {
+		return nil, errors.New("request too large")
+	}
+	return packet, nil
+}
+
+// Open 先校验HMAC再解密，返回数据包中的请求。不检查时间戳和Nonce
+func Open(key []byte, packet []byte) (*Request, error) {
+	if len(packet) < 1+ivSize+macSize+1 || len(packet) > MaxPacketSize || packet[0] != packetVersion {
+		return nil, ErrInvalidPacket
+	}
+	encKey, macKey := deriveKeys(key)
+
+	body, sum := packet[:len(packet)-macSize], packet[len(packet)-macSize:]
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return nil, ErrBadMAC
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(body)-1-ivSize)
+	cipher.NewCTR(block, body[1:1+ivSize]).XORKeyStream(plaintext, body[1+ivSize:])
+
+	var req Request
+	if err := json.Unmarshal(plaintext, &req); err != nil {
+		return nil, ErrInvalidPacket
+	}
+	return &req, nil
+}
@@ -0,0 +1,144 @@
+package spa
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"iptables-safe/access"
+	"iptables-safe/database"
+)
+
+var (
+	mu sync.Mutex
+	// listenPort 为正在监听的UDP端口，0表示未启用
+	listenPort int
+	// seenNonces 记录有效期内已使用的Nonce及其过期时间
+	seenNonces = make(map[string]time.Time)
+)
+
+// Port 返回单包授权监听的端口，未启用时返回0
+func Port() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return listenPort
+}
+
+// ListenAndServe 在UDP端口上接收单包授权请求，只在监听失败时返回
+func ListenAndServe(port int) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mu.Lock()
+	listenPort = port
+	mu.Unlock()
+	log.Printf("SPA listener started on udp/%d", port)
+
+	buf := make([]byte, MaxPacketSize+1)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		// 每个数据包只有一次机会，不回复任何内容，避免暴露监听端口
+		handlePacket(append([]byte(nil), buf[:n]...), addr.IP.String())
+	}
+}
+
+func handlePacket(packet []byte, ip string) {
+	config, err := database.GetConfig()
+	if err != nil {
+		log.Printf("Error getting config: %v", err)
+		return
+	}
+	if config.SPAKey == "" {
+		return
+	}
+	key, err := ParseKey(config.SPAKey)
+	if err != nil {
+		log.Printf("Invalid SPA key in config: %v", err)
+		return
+	}
+
+	// 未通过HMAC校验的数据包来源地址可能是伪造的，不计入登录失败
+	req, err := Open(key, packet)
+	if err != nil {
+		log.Printf("Ignored SPA packet from %s: %v", ip, err)
+		return
+	}
+	if err := checkFreshness(req, time.Now()); err != nil {
+		log.Printf("Rejected SPA request from %s: %v", ip, err)
+		return
+	}
+	if req.IP == "" {
+		log.Printf("Rejected SPA request from %s: no signed IP", ip)
+		return
+	}
+	if req.IP != ip {
+		log.Printf("Rejected SPA request from %s: signed for %s", ip, req.IP)
+		return
+	}
+	// SPA密钥由所有用户共享，不能区分用户，只能解锁默认登录；
+	// 其他服务需要通过服务密码、SSH或DNS解锁等可以按用户授权的方式解锁
+	if req.Service != "" {
+		log.Printf("Rejected SPA request from %s: service %q cannot be unlocked with the shared SPA key", ip, req.Service)
+		return
+	}
+
+	policy, err := access.ResolvePolicy(0)
+	if err != nil {
+		log.Printf("Rejected SPA request from %s: %v", ip, err)
+		return
+	}
+	if policy.IsLockedOut(ip) {
+		log.Printf("Ignored SPA request from locked out IP %s", ip)
+		return
+	}
+
+	description := "SPA"
+	if policy.ServiceName != "" {
+		description = "SPA: " + policy.ServiceName
+	}
+	expiresAt, err := access.Grant(ip, access.MethodSPA, policy, description)
+	if errors.Is(err, access.ErrCountryDenied) {
+		log.Printf("Rejected SPA request from %s: country not allowed", ip)
+		return
+	}
+	if err != nil {
+		log.Printf("Error whitelisting IP %s after SPA: %v", ip, err)
+		return
+	}
+	policy.RecordAttempt(ip, access.MethodSPA, true)
+	log.Printf("IP %s whitelisted by SPA until %s", ip, expiresAt.Format(time.RFC3339))
+}
+
+// checkFreshness 拒绝时间戳超出允许偏差或Nonce已使用过的请求
+func checkFreshness(req *Request, now time.Time) error {
+	sent := time.Unix(req.Timestamp, 0)
+	if sent.Before(now.Add(-MaxClockSkew)) || sent.After(now.Add(MaxClockSkew)) {
+		return fmt.Errorf("timestamp %s outside the allowed window", sent.Format(time.RFC3339))
+	}
+	if len(req.Nonce) < nonceSize {
+		return errors.New("nonce too short")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for nonce, expires := range seenNonces {
+		if now.After(expires) {
+			delete(seenNonces, nonce)
+		}
+	}
+	if _, ok := seenNonces[req.Nonce]; ok {
+		return errors.New("replayed nonce")
+	}
+	// 时间戳在 ±MaxClockSkew 内有效，Nonce 至少需要保留到该时间戳失效
+	seenNonces[req.Nonce] = sent.Add(MaxClockSkew)
+	return nil
+}
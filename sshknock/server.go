@@ -0,0 +1,242 @@
+// Package sshknock 提供一个只用于解锁的内置SSH服务：用户用自己的SSH密钥登录后，
+// 来源IP被加入白名单，服务端打印过期时间并断开，不提供shell
+package sshknock
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"iptables-safe/access"
+	"iptables-safe/database"
+)
+
+const (
+	// DefaultHostKeyPath 为未配置 ssh_host_key 时使用的主机密钥文件，不存在时自动生成
+	DefaultHostKeyPath = "./ssh_host_ed25519_key"
+	// handshakeTimeout 为完成认证的时限
+	handshakeTimeout = 30 * time.Second
+	// sessionTimeout 为认证后等待客户端打开会话以显示结果的时限，ssh -N 不会打开会话
+	sessionTimeout = 10 * time.Second
+)
+
+var (
+	mu          sync.Mutex
+	listenPort  int
+	fingerprint string
+)
+
+// Port 返回SSH解锁服务监听的端口，未启用时返回0
+func Port() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return listenPort
+}
+
+// HostKeyFingerprint 返回主机密钥的SHA256指纹，供用户首次连接时核对
+func HostKeyFingerprint() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return fingerprint
+}
+
+// ListenAndServe 在TCP端口上提供SSH解锁服务，只在监听失败时返回
+func ListenAndServe(port int, hostKeyPath string) error {
+	if hostKeyPath == "" {
+		hostKeyPath = DefaultHostKeyPath
+	}
+	signer, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load host key %s: %v", hostKeyPath, err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: authenticate,
+		ServerVersion:     "SSH-2.0-iptables-safe",
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	mu.Lock()
+	listenPort = port
+	fingerprint = ssh.FingerprintSHA256(signer.PublicKey())
+	mu.Unlock()
+	log.Printf("SSH knock server started on tcp/%d, host key %s", port, HostKeyFingerprint())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go handleConn(conn, config)
+	}
+}
+
+// loadHostKey 读取主机私钥，文件不存在时生成新的ed25519密钥并保存
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ssh.ParsePrivateKey(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "iptables-safe")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated SSH host key %s", path)
+	return ssh.NewSignerFromKey(key)
+}
+
+// authenticate 按指纹在受管公钥中查找客户端公钥，签名由ssh库校验
+func authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	k, err := database.GetSSHKeyByFingerprint(ssh.FingerprintSHA256(key))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up SSH key: %v", err)
+		}
+		return nil, errors.New("unknown key")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{
+		"key-id":  strconv.Itoa(k.ID),
+		"user":    k.UserName,
+		"user-id": strconv.Itoa(k.UserID),
+	}}, nil
+}
+
+func handleConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	// 一个客户端可能依次尝试多把密钥，整个连接认证失败才算一次登录失败
+	policy, err := access.ResolvePolicy(0)
+	if err != nil {
+		log.Printf("Error getting login policy: %v", err)
+		return
+	}
+	if policy.IsLockedOut(ip) {
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		var noAuth *ssh.ServerAuthError
+		if errors.As(err, &noAuth) {
+			policy.RecordAttempt(ip, access.MethodSSH, false)
+			log.Printf("SSH knock from %s failed: no authorized key", ip)
+		}
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	message := grant(ip, sshConn)
+
+	// 打印结果后断开；客户端没有打开会话时超时断开
+	conn.SetDeadline(time.Now().Add(sessionTimeout))
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		// 等到客户端请求shell或执行命令后再输出结果，pty-req、env 等请求直接接受
+		for req := range requests {
+			req.Reply(true, nil)
+			if req.Type == "shell" || req.Type == "exec" {
+				channel.Write([]byte(message))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				break
+			}
+		}
+		channel.Close()
+		return
+	}
+}
+
+// grant 按SSH用户名对应的服务解锁来源IP，返回显示给用户的结果。
+// SSH用户名与某个服务名称相同时解锁该服务，否则使用默认用户策略；
+// 用户只能解锁管理员为其授权的服务
+func grant(ip string, conn *ssh.ServerConn) string {
+	ext := conn.Permissions.Extensions
+	user := ext["user"]
+	keyID, _ := strconv.Atoi(ext["key-id"])
+	userID, _ := strconv.Atoi(ext["user-id"])
+
+	serviceID := 0
+	if s, err := database.GetServiceByName(conn.User()); err == nil {
+		serviceID = s.ID
+	}
+	u, err := database.GetUser(userID)
+	if err != nil {
+		log.Printf("Error getting user of SSH key: %v", err)
+		return "Internal server error\r\n"
+	}
+	policy, err := access.UserPolicy(u, serviceID)
+	if errors.Is(err, access.ErrServiceNotAllowed) {
+		log.Printf("Rejected SSH knock from %s (%s): service %q not allowed", ip, user, conn.User())
+		return "You are not allowed to unlock this service\r\n"
+	}
+	if err != nil {
+		log.Printf("Error getting login policy: %v", err)
+		return "Internal server error\r\n"
+	}
+	if policy.IsLockedOut(ip) {
+		return "Too many failed attempts. Please try again later.\r\n"
+	}
+
+	description := "SSH key: " + user
+	if policy.ServiceName != "" {
+		description = fmt.Sprintf("SSH key: %s (%s)", user, policy.ServiceName)
+	}
+	expiresAt, err := access.GrantUser(ip, userID, access.MethodSSH, policy, description)
+	if errors.Is(err, access.ErrCountryDenied) {
+		log.Printf("Rejected SSH knock from %s (%s): country not allowed", ip, user)
+		return "Access from your country is not allowed\r\n"
+	}
+	if err != nil {
+		log.Printf("Error whitelisting IP %s after SSH knock: %v", ip, err)
+		return "Failed to whitelist IP\r\n"
+	}
+
+	policy.RecordAttempt(ip, access.MethodSSH, true)
+	if err := database.TouchSSHKey(keyID, time.Now()); err != nil {
+		log.Printf("Error updating SSH key usage: %v", err)
+	}
+	log.Printf("IP %s whitelisted by SSH key of %s until %s", ip, user, expiresAt.Format(time.RFC3339))
+
+	ports := policy.Ports
+	if ports == "" {
+		ports = "all ports"
+	}
+	return fmt.Sprintf("Hello %s. Your IP %s has been whitelisted for %s until %s.\r\n",
+		user, ip, ports, expiresAt.Format("2006-01-02 15:04:05 MST"))
+}